@@ -0,0 +1,91 @@
+package riaken_core
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Balancer picks which node the next session is taken from.
+//
+// Pick is given the nodes currently in rotation, never empty, and the address of a
+// preferred node or an empty string.  It may be called concurrently.
+type Balancer interface {
+	Pick(nodes []*Node, hint string) *Node
+}
+
+// preferred returns the node at hint if it is one of nodes.
+func preferred(nodes []*Node, hint string) *Node {
+	if hint == "" {
+		return nil
+	}
+	for _, n := range nodes {
+		if n.addr == hint {
+			return n
+		}
+	}
+	return nil
+}
+
+// RoundRobinBalancer cycles through the nodes in turn.  This is the default.
+type RoundRobinBalancer struct {
+	next uint64
+}
+
+func (b *RoundRobinBalancer) Pick(nodes []*Node, hint string) *Node {
+	if n := preferred(nodes, hint); n != nil {
+		return n
+	}
+	i := atomic.AddUint64(&b.next, 1)
+	return nodes[int(i%uint64(len(nodes)))]
+}
+
+// LeastOutstandingBalancer picks the node with the fewest sessions checked out.
+type LeastOutstandingBalancer struct{}
+
+func (b *LeastOutstandingBalancer) Pick(nodes []*Node, hint string) *Node {
+	if n := preferred(nodes, hint); n != nil {
+		return n
+	}
+	best := nodes[0]
+	for _, n := range nodes[1:] {
+		if n.Outstanding() < best.Outstanding() {
+			best = n
+		}
+	}
+	return best
+}
+
+// LatencyBalancer picks the node with the lowest average latency, weighted by the
+// number of sessions it already has checked out so the fastest node is not swamped.
+type LatencyBalancer struct{}
+
+func (b *LatencyBalancer) Pick(nodes []*Node, hint string) *Node {
+	if n := preferred(nodes, hint); n != nil {
+		return n
+	}
+	var best *Node
+	var bestScore int64
+	for _, n := range nodes {
+		score := int64(n.Latency()) * int64(n.Outstanding()+1)
+		if best == nil || score < bestScore {
+			best = n
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// TwoChoicesBalancer picks two nodes at random and uses the one with fewer sessions checked out.
+type TwoChoicesBalancer struct{}
+
+func (b *TwoChoicesBalancer) Pick(nodes []*Node, hint string) *Node {
+	if n := preferred(nodes, hint); n != nil {
+		return n
+	}
+	n1 := nodes[rand.Intn(len(nodes))]
+	n2 := nodes[rand.Intn(len(nodes))]
+	if n2.Outstanding() < n1.Outstanding() {
+		return n2
+	}
+	return n1
+}
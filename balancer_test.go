@@ -0,0 +1,92 @@
+package riaken_core
+
+import (
+	"testing"
+	"time"
+)
+
+func balancerNodes() []*Node {
+	client := &Client{}
+	return []*Node{
+		newNode("127.0.0.1:10017", 0, client),
+		newNode("127.0.0.1:10027", 0, client),
+		newNode("127.0.0.1:10037", 0, client),
+	}
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	nodes := balancerNodes()
+	b := &RoundRobinBalancer{}
+	seen := make(map[*Node]int)
+	for i := 0; i < 9; i++ {
+		seen[b.Pick(nodes, "")]++
+	}
+	for _, n := range nodes {
+		if seen[n] != 3 {
+			t.Errorf("expected: %d, got: %d for %s", 3, seen[n], n.Addr())
+		}
+	}
+	if n := b.Pick(nodes, "127.0.0.1:10027"); n != nodes[1] {
+		t.Errorf("expected hint to be honored, got: %s", n.Addr())
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	nodes := balancerNodes()
+	nodes[0].outstanding = 2
+	nodes[1].outstanding = 1
+	nodes[2].outstanding = 3
+	if n := (&LeastOutstandingBalancer{}).Pick(nodes, ""); n != nodes[1] {
+		t.Errorf("expected: %s, got: %s", nodes[1].Addr(), n.Addr())
+	}
+	if n := (&TwoChoicesBalancer{}).Pick(nodes[2:], ""); n != nodes[2] {
+		t.Errorf("expected: %s, got: %s", nodes[2].Addr(), n.Addr())
+	}
+}
+
+func TestBalancerLatency(t *testing.T) {
+	nodes := balancerNodes()
+	nodes[0].success(time.Millisecond * 50)
+	nodes[1].success(time.Millisecond * 5)
+	nodes[2].success(time.Millisecond * 20)
+	b := &LatencyBalancer{}
+	if n := b.Pick(nodes, ""); n != nodes[1] {
+		t.Errorf("expected: %s, got: %s", nodes[1].Addr(), n.Addr())
+	}
+	// a busy fast node loses to an idle slower one
+	nodes[1].outstanding = 5
+	if n := b.Pick(nodes, ""); n != nodes[2] {
+		t.Errorf("expected: %s, got: %s", nodes[2].Addr(), n.Addr())
+	}
+}
+
+func TestClientBalancer(t *testing.T) {
+	addr1, stop1 := fakeServer(t, fakeEcho)
+	defer stop1()
+	addr2, stop2 := fakeServer(t, fakeEcho)
+	defer stop2()
+	client := NewClient([]string{addr1, addr2}, 2)
+	client.SetBalancer(&LeastOutstandingBalancer{})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	s1 := client.Session()
+	s2 := client.Session()
+	if s1.addr == s2.addr {
+		t.Error("expected sessions to be spread across nodes")
+	}
+	s3 := client.SessionOn(addr1)
+	if s3.addr != addr1 {
+		t.Errorf("expected: %s, got: %s", addr1, s3.addr)
+	}
+	s1.Release()
+	s2.Release()
+	s3.Release()
+	for _, st := range client.NodeStatus() {
+		if st.Outstanding != 0 {
+			t.Errorf("expected no outstanding sessions on %s, got: %d", st.Addr, st.Outstanding)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package riaken_core
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// BatchResult holds the outcome of a single operation in a Batch.
+//
+// Value is *rpb.RpbPutResp for Store, bool for Delete, and *rpb.DtUpdateResp for Update.
+type BatchResult struct {
+	Value interface{}
+	Err   error
+}
+
+type batchOp struct {
+	code byte                                   // rpb request code
+	in   []byte                                 // marshaled request
+	err  error                                  // error building the request
+	done func(interface{}) (interface{}, error) // process the response
+}
+
+// Batch gathers Store, Delete and Update operations and pipelines them over a single session.
+//
+// All requests are written back to back before any response is read, so a batch costs roughly
+// one round trip instead of one per operation.  Options set through Do() on each Object or Crdt
+// are honored.  Every operation is sent over the session the batch was created from.
+//
+//	batch := session.Batch()
+//	batch.Store(bucket.Object("o1"), []byte("o1-data"))
+//	batch.Store(bucket.Object("o2"), []byte("o2-data"))
+//	batch.Delete(bucket.Object("o3"))
+//	results, err := batch.Exec()
+type Batch struct {
+	session *Session
+	ops     []*batchOp
+}
+
+// Store queues a Store of data for object o.
+func (b *Batch) Store(o *Object, data []byte) *Batch {
+	in, err := marshal(o.storeReq(data))
+	b.ops = append(b.ops, &batchOp{
+		code: Messages["PutReq"],
+		in:   in,
+		err:  err,
+		done: func(out interface{}) (interface{}, error) {
+			return out.(*rpb.RpbPutResp), o.processStore(out.(*rpb.RpbPutResp))
+		},
+	})
+	return b
+}
+
+// Delete queues a Delete for object o.
+func (b *Batch) Delete(o *Object) *Batch {
+	in, err := marshal(o.deleteReq())
+	b.ops = append(b.ops, &batchOp{
+		code: Messages["DelReq"],
+		in:   in,
+		err:  err,
+		done: func(out interface{}) (interface{}, error) {
+			o.deleted = true
+			return out.(bool), nil
+		},
+	})
+	return b
+}
+
+// Update queues an Update for CRDT dt.  The update operation should be passed via dt.Do().
+func (b *Batch) Update(dt *Crdt) *Batch {
+	in, err := marshal(dt.updateReq())
+	b.ops = append(b.ops, &batchOp{
+		code: Messages["DtUpdateReq"],
+		in:   in,
+		err:  err,
+		done: func(out interface{}) (interface{}, error) {
+			dt.processUpdate(out.(*rpb.DtUpdateResp))
+			return out.(*rpb.DtUpdateResp), nil
+		},
+	})
+	return b
+}
+
+// marshal marshals a request built for a batch right away, since options set through Do may
+// be reused for the next operation.
+func marshal(msg proto.Message, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+// Len returns the number of queued operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Exec sends all queued operations and returns a result for each one in the order they were queued.
+//
+// A non-nil error means the connection failed and the outcome of the batch is unknown.
+// The batch is empty after Exec and can be reused.
+func (b *Batch) Exec() (_ []BatchResult, err error) {
+	sp := b.session.trace("Batch", "", "", "")
+	defer sp.end(&err)
+	ops := b.ops
+	b.ops = nil
+	results := make([]BatchResult, len(ops))
+	var reqs []pipelineReq
+	var sent []int
+	for i, op := range ops {
+		if op.err != nil {
+			results[i].Err = op.err
+			continue
+		}
+		reqs = append(reqs, pipelineReq{code: op.code, in: op.in})
+		sent = append(sent, i)
+	}
+	if len(reqs) == 0 {
+		return results, nil
+	}
+	out, errs, err := b.session.pipeline(reqs)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range sent {
+		if errs[j] != nil {
+			results[i].Err = errs[j]
+			continue
+		}
+		results[i].Value, results[i].Err = ops[i].done(out[j])
+	}
+	return results, nil
+}
@@ -0,0 +1,246 @@
+package riaken_core
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestBatch(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	bucket := session.GetBucket("b1-batch")
+	batch := session.Batch()
+	for i := 0; i < 50; i++ {
+		batch.Store(bucket.Object(fmt.Sprintf("o%d", i)), []byte(fmt.Sprintf("o%d-data", i)))
+	}
+	if batch.Len() != 50 {
+		t.Errorf("expected: %d, got: %d", 50, batch.Len())
+	}
+	results, err := batch.Exec()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Errorf("store %d: %s", i, res.Err.Error())
+		}
+	}
+
+	for i := 0; i < 50; i += 10 {
+		data, err := bucket.Object(fmt.Sprintf("o%d", i)).Fetch()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(data.GetContent()) > 0 {
+			if string(data.GetContent()[0].GetValue()) != fmt.Sprintf("o%d-data", i) {
+				t.Errorf("got %s, expected o%d-data", string(data.GetContent()[0].GetValue()), i)
+			}
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		batch.Delete(bucket.Object(fmt.Sprintf("o%d", i)))
+	}
+	results, err = batch.Exec()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Errorf("delete %d: %s", i, res.Err.Error())
+		} else if !res.Value.(bool) {
+			t.Errorf("delete %d failed", i)
+		}
+	}
+}
+
+func TestBatchMixed(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	bucket := session.GetBucket("b1-batch")
+	counters := session.GetBucket("crdt_counter").Type("test_counters")
+	crdt := counters.Crdt("batch")
+	crdt.Do(&rpb.DtUpdateReq{
+		Op: &rpb.DtOp{
+			CounterOp: &rpb.CounterOp{
+				Increment: proto.Int64(2),
+			},
+		},
+		ReturnBody: proto.Bool(true),
+	})
+
+	results, err := session.Batch().
+		Store(bucket.Object("mixed"), []byte("mixed-data")).
+		Store(bucket.Object("bad").Do(&rpb.RpbGetReq{}), []byte("bad")).
+		Update(crdt).
+		Delete(bucket.Object("mixed")).
+		Exec()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected: %d, got: %d", 4, len(results))
+	}
+	if results[1].Err == nil {
+		t.Error("expected wrong opts error")
+	}
+	if results[2].Err != nil {
+		t.Error(results[2].Err.Error())
+	} else if crdt.Counter.Value < 2 {
+		t.Errorf("expected counter >= 2, got: %d", crdt.Counter.Value)
+	}
+
+	if _, err := counters.Object("batch").Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestBatchPipeline(t *testing.T) {
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		if code != Messages["PutReq"] {
+			return fakeEcho(code, in)
+		}
+		req := &rpb.RpbPutReq{}
+		if err := proto.Unmarshal(in, req); err != nil {
+			t.Error(err.Error())
+		}
+		if string(req.GetKey()) == "fail" {
+			out, _ := proto.Marshal(&rpb.RpbErrorResp{
+				Errmsg:  []byte("failed"),
+				Errcode: proto.Uint32(1),
+			})
+			return Messages["ErrorResp"], out
+		}
+		out, _ := proto.Marshal(&rpb.RpbPutResp{Key: req.GetKey()})
+		return Messages["PutResp"], out
+	})
+	defer stop()
+
+	bucket := session.GetBucket("b1-batch")
+	batch := session.Batch()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("o%d", i)
+		if i == 500 {
+			key = "fail"
+		}
+		batch.Store(bucket.Object(key), make([]byte, 1024))
+	}
+	results, err := batch.Exec()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, res := range results {
+		if i == 500 {
+			if res.Err == nil {
+				t.Error("expected riak error")
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Fatal(res.Err.Error())
+		}
+		if key := string(res.Value.(*rpb.RpbPutResp).GetKey()); key != fmt.Sprintf("o%d", i) {
+			t.Errorf("expected: o%d, got: %s", i, key)
+		}
+	}
+	if !session.Ping() {
+		t.Error("expected session to remain usable")
+	}
+}
+
+func TestBatchPipelineReusedOpts(t *testing.T) {
+	var stored []string
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		req := &rpb.RpbPutReq{}
+		proto.Unmarshal(in, req)
+		stored = append(stored, string(req.GetKey())+"="+string(req.GetContent().GetValue()))
+		return Messages["PutResp"], nil
+	})
+	defer stop()
+
+	bucket := session.GetBucket("b1")
+	opts := &rpb.RpbPutReq{W: proto.Uint32(1)}
+	batch := session.Batch()
+	for i := 0; i < 3; i++ {
+		batch.Store(bucket.Object(fmt.Sprintf("o%d", i)).Do(opts), []byte(fmt.Sprintf("v%d", i)))
+	}
+	if _, err := batch.Exec(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if fmt.Sprint(stored) != "[o0=v0 o1=v1 o2=v2]" {
+		t.Errorf("unexpected stores: %v", stored)
+	}
+}
+
+func TestBatchPipelineReaderFails(t *testing.T) {
+	done := make(chan bool)
+	defer close(done)
+	info, _ := proto.Marshal(&rpb.RpbGetServerInfoResp{ServerVersion: []byte("2.1.4")})
+	client := NewClient([]string{"node1"}, 1)
+	client.SetDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			r := bufio.NewReader(c2)
+			head := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(r, head); err != nil {
+					return
+				}
+				frame := make([]byte, binary.BigEndian.Uint32(head))
+				if _, err := io.ReadFull(r, frame); err != nil {
+					return
+				}
+				if frame[0] == Messages["GetServerInfoReq"] {
+					resp := []byte{0, 0, 0, byte(len(info) + 1), Messages["GetServerInfoResp"]}
+					c2.Write(append(resp, info...))
+					continue
+				}
+				// answer with an empty frame and stop reading the pipeline
+				c2.Write([]byte{0, 0, 0, 0})
+				<-done
+				return
+			}
+		}()
+		return c1, nil
+	})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	batch := session.Batch()
+	for i := 0; i < 10; i++ {
+		batch.Store(session.GetBucket("b1").Object(fmt.Sprintf("o%d", i)), make([]byte, 1024))
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := batch.Exec()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != ErrZeroLength {
+			t.Errorf("expected: %v, got: %v", ErrZeroLength, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline blocked on its writer")
+	}
+}
@@ -0,0 +1,91 @@
+package riaken_core
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// benchSession dials a client of one session against a fakeServer answering every request.
+func benchSession(b *testing.B) (*Session, func()) {
+	get, _ := proto.Marshal(&rpb.RpbGetResp{
+		Content: []*rpb.RpbContent{{Value: make([]byte, 1024)}},
+		Vclock:  []byte("vclock"),
+	})
+	addr, stop := fakeServer(b, func(code byte, in []byte) (byte, []byte) {
+		if code == Messages["GetReq"] {
+			return Messages["GetResp"], get
+		}
+		return fakeEcho(code, in)
+	})
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		stop()
+		b.Fatal(err.Error())
+	}
+	session := client.Session()
+	return session, func() {
+		session.Release()
+		client.Close()
+		stop()
+	}
+}
+
+func BenchmarkSessionPing(b *testing.B) {
+	session, stop := benchSession(b)
+	defer stop()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !session.Ping() {
+			b.Fatal("no ping response")
+		}
+	}
+}
+
+func BenchmarkObjectFetch(b *testing.B) {
+	session, stop := benchSession(b)
+	defer stop()
+	object := session.GetBucket("b1").Object("o1")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := object.Fetch(); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkObjectStore(b *testing.B) {
+	session, stop := benchSession(b)
+	defer stop()
+	object := session.GetBucket("b1").Object("o1")
+	data := make([]byte, 1024)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := object.Store(data); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkBatchStore(b *testing.B) {
+	session, stop := benchSession(b)
+	defer stop()
+	bucket := session.GetBucket("b1")
+	data := make([]byte, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch := session.Batch()
+		for j := 0; j < 10; j++ {
+			batch.Store(bucket.Object("o1"), data)
+		}
+		if _, err := batch.Exec(); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
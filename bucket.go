@@ -1,7 +1,6 @@
 package riaken_core
 
 import (
-	"github.com/golang/protobuf/proto"
 	"github.com/riaken/riaken-core/rpb"
 )
 
@@ -10,6 +9,7 @@ type Bucket struct {
 	name        string   // bucket name to associate with
 	streamState int      // track state of streaming
 	btype       []byte   // track the bucket type
+	cipher      Cipher   // encrypts stored values, if set
 }
 
 // Type allows the bucket type to be set.  Chains with additional methods.
@@ -33,20 +33,20 @@ func (b *Bucket) Type(t string) *Bucket {
 //	}
 //
 // Riak docs - Not for production use: This operation requires traversing all keys stored in the cluster and should not be used in production.
-func (b *Bucket) ListKeys() (*rpb.RpbListKeysResp, error) {
-	var err error
+func (b *Bucket) ListKeys() (_ *rpb.RpbListKeysResp, err error) {
+	sp := b.session.trace("ListKeys", string(b.btype), b.name, "")
+	defer sp.end(&err)
 	var out interface{}
 	switch b.streamState {
 	case 0:
+		if err := b.session.requireType(b.btype); err != nil {
+			return nil, err
+		}
 		opts := &rpb.RpbListKeysReq{
 			Type:   b.btype,
 			Bucket: []byte(b.name),
 		}
-		in, err := proto.Marshal(opts)
-		if err != nil {
-			return nil, err
-		}
-		out, err = b.session.execute(Messages["ListKeysReq"], in)
+		out, err = b.session.execute(Messages["ListKeysReq"], Messages["ListKeysResp"], opts)
 		if err != nil {
 			return nil, err
 		}
@@ -54,7 +54,7 @@ func (b *Bucket) ListKeys() (*rpb.RpbListKeysResp, error) {
 
 		// Fall through and do an initial read as well
 	case 1:
-		out, err = b.session.executeRead()
+		out, err = b.session.executeRead(Messages["ListKeysReq"])
 		if err != nil {
 			return nil, err
 		}
@@ -67,34 +67,43 @@ func (b *Bucket) ListKeys() (*rpb.RpbListKeysResp, error) {
 
 // GetBucketProps returns the properties for this bucket.
 func (b *Bucket) GetBucketProps() (*rpb.RpbGetBucketResp, error) {
+	if err := b.session.requireType(b.btype); err != nil {
+		return nil, err
+	}
 	opts := &rpb.RpbGetBucketReq{
 		Type:   b.btype,
 		Bucket: []byte(b.name),
 	}
-	in, err := proto.Marshal(opts)
-	out, err := b.session.execute(Messages["GetBucketReq"], in)
-	if err != nil {
+	out := new(rpb.RpbGetBucketResp)
+	if err := b.session.Execute(&ProtoCommand{
+		Code:     Messages["GetBucketReq"],
+		Req:      opts,
+		RespCode: Messages["GetBucketResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	return out.(*rpb.RpbGetBucketResp), nil
+	return out, nil
 }
 
 // SetBucketProps set the properties for this bucket using RpbBucketProps.
 func (b *Bucket) SetBucketProps(props *rpb.RpbBucketProps) (bool, error) {
+	if err := b.session.requireType(b.btype); err != nil {
+		return false, err
+	}
 	opts := &rpb.RpbSetBucketReq{
 		Type:   b.btype,
 		Bucket: []byte(b.name),
 		Props:  props,
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
+	if err := b.session.Execute(&ProtoCommand{
+		Code:     Messages["SetBucketReq"],
+		Req:      opts,
+		RespCode: Messages["SetBucketResp"],
+	}); err != nil {
 		return false, err
 	}
-	out, err := b.session.execute(Messages["SetBucketReq"], in)
-	if err != nil {
-		return false, err
-	}
-	return out.(bool), nil
+	return true, nil
 }
 
 // SetBucketType sets the type for this bucket (set via Type()) along with optional RpbBucketProps.
@@ -103,32 +112,33 @@ func (b *Bucket) SetBucketType(props *rpb.RpbBucketProps) (bool, error) {
 		Type:  b.btype,
 		Props: props,
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return false, err
-	}
-	out, err := b.session.execute(Messages["SetBucketTypeReq"], in)
-	if err != nil {
+	if err := b.session.Execute(&ProtoCommand{
+		Code:     Messages["SetBucketTypeReq"],
+		Req:      opts,
+		RespCode: Messages["SetBucketResp"],
+	}); err != nil {
 		return false, err
 	}
-	return out.(bool), nil
+	return true, nil
 }
 
 // ResetBucket resets the bucket type for bucket with type set via Type().
 func (b *Bucket) ResetBucket() (bool, error) {
+	if err := b.session.requireType(b.btype); err != nil {
+		return false, err
+	}
 	opts := &rpb.RpbResetBucketReq{
 		Type:   b.btype,
 		Bucket: []byte(b.name),
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return false, err
-	}
-	out, err := b.session.execute(Messages["ResetBucketReq"], in)
-	if err != nil {
+	if err := b.session.Execute(&ProtoCommand{
+		Code:     Messages["ResetBucketReq"],
+		Req:      opts,
+		RespCode: Messages["ResetBucketResp"],
+	}); err != nil {
 		return false, err
 	}
-	return out.(bool), nil
+	return true, nil
 }
 
 // Object returns a new object associated with this bucket using key.
@@ -0,0 +1,230 @@
+package riaken_core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// CIPHER_KEY_META is the usermeta entry holding the ID of the key a value was encrypted with.
+const CIPHER_KEY_META string = "riaken-key-id"
+
+var ErrNoCipher error = errors.New("bucket has no cipher to decrypt or rotate values")
+var ErrUnknownKey error = errors.New("value is encrypted with an unknown key")
+var ErrNoKey error = errors.New("encrypted objects need a key")
+var ErrNotEncrypted error = errors.New("value in a bucket with a cipher is not encrypted")
+
+// Cipher encrypts and authenticates object values.
+//
+// Values are sealed with the key named by KeyID, which is stored in usermeta so Open can
+// pick the same key later.  additional is authenticated but not encrypted.
+type Cipher interface {
+	KeyID() string
+	Seal(keyID string, plaintext, additional []byte) ([]byte, error)
+	Open(keyID string, ciphertext, additional []byte) ([]byte, error)
+}
+
+// AESGCMCipher encrypts with AES-GCM under a current key, and decrypts with any known key.
+type AESGCMCipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewAESGCMCipher returns a cipher which encrypts with keys[current].  Keys must be 16, 24 or 32
+// bytes long.  Older keys stay in keys so existing values can be read until they are rotated.
+func NewAESGCMCipher(current string, keys map[string][]byte) (*AESGCMCipher, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, ErrUnknownKey
+	}
+	c := &AESGCMCipher{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// KeyID returns the ID of the key new values are encrypted with.
+func (c *AESGCMCipher) KeyID() string {
+	return c.current
+}
+
+// Seal encrypts plaintext with a random nonce, which is prepended to the ciphertext.
+func (c *AESGCMCipher) Seal(keyID string, plaintext, additional []byte) ([]byte, error) {
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func (c *AESGCMCipher) Open(keyID string, ciphertext, additional []byte) ([]byte, error) {
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], additional)
+}
+
+// Cipher sets the cipher used to encrypt values stored in this bucket and decrypt them on Fetch.
+// Chains with additional methods.
+func (b *Bucket) Cipher(c Cipher) *Bucket {
+	b.cipher = c
+	return b
+}
+
+// additional returns the data authenticated along with a value, tying it to its location.
+func (o *Object) additional() []byte {
+	return []byte(string(o.bucket.btype) + "\x00" + o.bucket.name + "\x00" + o.key)
+}
+
+// encrypt replaces the value of content with its ciphertext if the bucket has a cipher.
+func (o *Object) encrypt(content *rpb.RpbContent) error {
+	if o.bucket.cipher == nil {
+		return nil
+	}
+	if o.key == "" {
+		return ErrNoKey
+	}
+	id := o.bucket.cipher.KeyID()
+	data, err := o.bucket.cipher.Seal(id, content.Value, o.additional())
+	if err != nil {
+		return err
+	}
+	content.Value = data
+	content.Usermeta = append(withoutKeyID(content.Usermeta), &rpb.RpbPair{
+		Key:   []byte(CIPHER_KEY_META),
+		Value: []byte(id),
+	})
+	return nil
+}
+
+// decrypt replaces encrypted values in contents with their plaintext.  The key ID is left in
+// usermeta, and replaced when the value is stored again.
+//
+// If the bucket has a cipher every value must be encrypted, otherwise anyone able to write
+// to the bucket without the key could plant values which are taken as genuine.
+func (o *Object) decrypt(contents []*rpb.RpbContent) error {
+	for _, content := range contents {
+		if len(content.Value) == 0 {
+			continue
+		}
+		id, ok := keyID(content)
+		if !ok {
+			if o.bucket.cipher != nil {
+				return ErrNotEncrypted
+			}
+			continue
+		}
+		if o.bucket.cipher == nil {
+			return ErrNoCipher
+		}
+		data, err := o.bucket.cipher.Open(id, content.Value, o.additional())
+		if err != nil {
+			return err
+		}
+		content.Value = data
+	}
+	return nil
+}
+
+// keyID returns the ID of the key content was encrypted with, if any.
+func keyID(content *rpb.RpbContent) (string, bool) {
+	for _, p := range content.GetUsermeta() {
+		if string(p.GetKey()) == CIPHER_KEY_META {
+			return string(p.GetValue()), true
+		}
+	}
+	return "", false
+}
+
+// withoutKeyID returns meta without the key ID entry.
+func withoutKeyID(meta []*rpb.RpbPair) []*rpb.RpbPair {
+	var out []*rpb.RpbPair
+	for _, p := range meta {
+		if string(p.GetKey()) != CIPHER_KEY_META {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// RotateError lists the keys Rotate failed on, along with the error for each.
+type RotateError struct {
+	Failed map[string]error
+}
+
+func (e *RotateError) Error() string {
+	return fmt.Sprintf("rotating %d keys failed", len(e.Failed))
+}
+
+// Rotate re-encrypts the objects at keys which were encrypted with another key than the one
+// the bucket cipher currently uses, and returns how many were rewritten.  Keys can come from
+// ListKeys or a secondary index query.
+//
+// Writes are conditional, so objects changed in the meantime are skipped along with objects
+// which have siblings.  Those are rotated by a later run or their next Store.  Keys which
+// cannot be read or written, such as values failing authentication, do not stop the others
+// and are returned in a *RotateError.
+func (b *Bucket) Rotate(keys []string) (int, error) {
+	if b.cipher == nil {
+		return 0, ErrNoCipher
+	}
+	rotated := 0
+	failed := make(map[string]error)
+	for _, key := range keys {
+		o := b.Object(key)
+		res, err := o.Fetch()
+		if err != nil {
+			failed[key] = err
+			continue
+		}
+		content := res.GetContent()
+		if len(content) != 1 {
+			continue
+		}
+		if id, ok := keyID(content[0]); !ok || id == b.cipher.KeyID() {
+			continue
+		}
+		opts, err := o.putOpts()
+		if err != nil {
+			return rotated, err
+		}
+		opts.IfNotModified = proto.Bool(true)
+		if err := o.StoreValue(newValue(content[0])); err != nil {
+			if modifiedErr(err) != ErrObjectModified {
+				failed[key] = err
+			}
+			continue
+		}
+		rotated++
+	}
+	if len(failed) > 0 {
+		return rotated, &RotateError{Failed: failed}
+	}
+	return rotated, nil
+}
@@ -0,0 +1,132 @@
+package riaken_core
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// fakeStore is a fakeHandler keeping the last stored content of each key.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string]*rpb.RpbContent
+}
+
+func (f *fakeStore) handle(code byte, in []byte) (byte, []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch code {
+	case Messages["PutReq"]:
+		req := &rpb.RpbPutReq{}
+		proto.Unmarshal(in, req)
+		f.objects[string(req.GetKey())] = req.GetContent()
+		return Messages["PutResp"], nil
+	case Messages["GetReq"]:
+		req := &rpb.RpbGetReq{}
+		proto.Unmarshal(in, req)
+		res := &rpb.RpbGetResp{Vclock: []byte("vclock")}
+		if c, ok := f.objects[string(req.GetKey())]; ok {
+			res.Content = []*rpb.RpbContent{c}
+		}
+		out, _ := proto.Marshal(res)
+		return Messages["GetResp"], out
+	case Messages["DelReq"]:
+		req := &rpb.RpbDelReq{}
+		proto.Unmarshal(in, req)
+		delete(f.objects, string(req.GetKey()))
+	}
+	return fakeEcho(code, in)
+}
+
+func (f *fakeStore) get(key string) *rpb.RpbContent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return proto.Clone(f.objects[key]).(*rpb.RpbContent)
+}
+
+func TestObjectCipher(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	addr, stop := fakeServer(t, store.handle)
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	client.SetCompression("gzip", 10)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 32)
+	c1, err := NewAESGCMCipher("k1", map[string][]byte{"k1": k1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	secret := bytes.Repeat([]byte("secret "), 10)
+	bucket := session.GetBucket("pii").Cipher(c1)
+	for _, key := range []string{"o1", "o2"} {
+		if _, err := bucket.Object(key).Store(secret); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	stored := store.get("o1")
+	if id, _ := keyID(stored); id != "k1" || bytes.Contains(stored.GetValue(), []byte("secret")) {
+		t.Errorf("value was not encrypted: %v", stored)
+	}
+	res, err := bucket.Object("o1").Fetch()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(res.GetContent()[0].GetValue(), secret) {
+		t.Errorf("value was not decrypted: %q", res.GetContent()[0].GetValue())
+	}
+	if _, err := session.GetBucket("pii").Object("o1").Fetch(); err != ErrNoCipher {
+		t.Errorf("expected: %v, got: %v", ErrNoCipher, err)
+	}
+
+	// a value copied to another key fails authentication
+	store.mu.Lock()
+	store.objects["copy"] = store.objects["o1"]
+	store.mu.Unlock()
+	if _, err := bucket.Object("copy").Fetch(); err == nil {
+		t.Error("expected a moved value to fail authentication")
+	}
+
+	c2, err := NewAESGCMCipher("k2", map[string][]byte{"k1": k1, "k2": k2})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// a value written without the key is not trusted
+	store.mu.Lock()
+	store.objects["plain"] = &rpb.RpbContent{Value: []byte("planted")}
+	store.mu.Unlock()
+	if _, err := bucket.Object("plain").Fetch(); err != ErrNotEncrypted {
+		t.Errorf("expected: %v, got: %v", ErrNotEncrypted, err)
+	}
+
+	bucket.Cipher(c2)
+	n, err := bucket.Rotate([]string{"copy", "o1", "plain", "o2", "missing"})
+	if e, ok := err.(*RotateError); !ok || len(e.Failed) != 2 || e.Failed["plain"] != ErrNotEncrypted || e.Failed["copy"] == nil {
+		t.Errorf("expected copy and plain to fail, got: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected: 2 rotated, got: %d", n)
+	}
+	if id, _ := keyID(store.get("o2")); id != "k2" {
+		t.Errorf("expected: k2, got: %s", id)
+	}
+	res, err = session.GetBucket("pii").Cipher(c2).Object("o2").Fetch()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(res.GetContent()[0].GetValue(), secret) {
+		t.Errorf("value was not decrypted after rotation: %q", res.GetContent()[0].GetValue())
+	}
+	if n, err := bucket.Rotate([]string{"o1", "o2"}); n != 0 || err != nil {
+		t.Errorf("expected nothing to rotate, got: %d, %v", n, err)
+	}
+}
@@ -1,76 +1,400 @@
 package riaken_core
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
-	"log"
+	"sync"
 	"time"
 )
 
+// PingRate is the default interval between health checks of each session.
 const PingRate time.Duration = time.Second * 10
 
 var ErrAllNodesDown error = errors.New("all nodes appear to be down")
+var ErrNodeExists error = errors.New("node is already part of the cluster")
+var ErrNodeNotFound error = errors.New("node is not part of the cluster")
+var ErrNodeRemoved error = errors.New("node has been removed from the cluster")
+var ErrInvalidConns error = errors.New("a node needs at least one connection")
+var ErrNoNodes error = errors.New("no node addresses given")
+var ErrClientClosed error = errors.New("client has been shut down")
 
 type Client struct {
-	cluster  chan *Session
-	debug    bool      // toggle debug output
-	shutdown chan bool // shutdown channel
+	mu          sync.RWMutex   // guards nodes
+	nodes       []*Node        // nodes in the cluster, each with its own session pool
+	draining    map[*Node]bool // removed nodes whose sessions are still being closed
+	conns       int            // connections to maintain per node
+	balancer    Balancer       // picks which node hands out the next session
+	pingRate    time.Duration  // interval between health checks
+	breaker     CircuitBreaker // when to pull nodes out of rotation
+	debug       bool           // toggle debug output on the default logger
+	logger      Logger         // diagnostic output, nil for the default logger
+	observer    Observer       // instrumentation hooks, if any
+	tracer      Tracer         // starts a span per operation, if any
+	hashKeys    bool           // hash keys before adding them to spans
+	compression string         // content encoding used to compress stored values, if any
+	compressAt  int            // smallest value in bytes which is compressed
+	clientId    ClientIdFunc   // client ID set on every new connection, if any
+	maxFrame    int            // largest response frame accepted, 0 for MaxFrameSize
+	dialer      Dialer         // opens connections, nil to dial TCP with config
+	config      ClientConfig   // connection settings, per node if need be
+	shutdown    chan bool      // closed on shutdown
+	once        sync.Once      // guards closing shutdown
 }
 
 // NewClient takes a list of Riak node addresses to connect to and the max number of connections to maintain per node.
 func NewClient(addrs []string, max int) *Client {
+	return NewClientConfig(addrs, max, ClientConfig{})
+}
+
+// NewClientConfig is NewClient with connection settings such as timeouts and TCP options,
+// which can be set per node address.
+func NewClientConfig(addrs []string, max int, config ClientConfig) *Client {
 	client := &Client{
-		cluster:  make(chan *Session, len(addrs)*max),
+		config:   config,
+		draining: make(map[*Node]bool),
+		conns:    max,
+		balancer: &RoundRobinBalancer{},
+		pingRate: PingRate,
+		breaker:  DefaultCircuitBreaker,
 		shutdown: make(chan bool),
 	}
 	for _, addr := range addrs {
-		for i := 0; i < max; i++ {
-			client.cluster <- NewSession(client.cluster, addr)
-		}
+		client.nodes = append(client.nodes, newNode(addr, max, client))
 	}
 	return client
 }
 
+// Debug toggles debug output on the default logger.  It has no effect once SetLogger is used.
 func (c *Client) Debug(debug bool) {
 	c.debug = debug
 }
 
+// SetLogger sets where diagnostic output is sent.  Call before Dial.
+//
+// By default warnings and errors are written with the standard library log package.
+func (c *Client) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+// SetObserver sets the instrumentation hooks called for every request and pool event.  Call before Dial.
+func (c *Client) SetObserver(observer Observer) {
+	c.observer = observer
+}
+
+// SetTracer sets the tracer which starts a span for every Riak operation.  Call before Dial.
+//
+// If hashKeys is set keys are recorded as a truncated SHA-256 hash rather than in the clear.
+func (c *Client) SetTracer(tracer Tracer, hashKeys bool) {
+	c.tracer = tracer
+	c.hashKeys = hashKeys
+}
+
+// ClientIdFunc returns the client ID to set on a new connection to the node at addr.
+type ClientIdFunc func(addr string) []byte
+
+// FixedClientId sets the same client ID on every connection.
+func FixedClientId(id []byte) ClientIdFunc {
+	return func(addr string) []byte {
+		return id
+	}
+}
+
+// RandomClientId sets a new random 4 byte client ID on every connection.
+func RandomClientId() ClientIdFunc {
+	return func(addr string) []byte {
+		id := make([]byte, 4)
+		rand.Read(id)
+		return id
+	}
+}
+
+// SetClientId sets the strategy used to pick a client ID for every connection, which is
+// applied after each dial and redial.  Call before Dial.
+func (c *Client) SetClientId(f ClientIdFunc) {
+	c.clientId = f
+}
+
+// SetDialer sets how connections to nodes are opened.  Defaults to TCP with the settings given
+// to NewClientConfig.  Call before Dial.
+func (c *Client) SetDialer(dialer Dialer) {
+	c.dialer = dialer
+}
+
+// SetMaxFrameSize sets the largest response frame accepted from Riak.  A larger frame fails
+// with a *FrameSizeError and the connection is closed.  Defaults to MaxFrameSize.
+func (c *Client) SetMaxFrameSize(size int) {
+	c.maxFrame = size
+}
+
+// SetCompression compresses values of at least threshold bytes on Store with the compressor
+// registered for encoding, such as "gzip", and sets content_encoding to match.  Call before Dial.
+//
+// Fetch decompresses any value with a registered content encoding, whether or not this is set.
+func (c *Client) SetCompression(encoding string, threshold int) {
+	c.compression = encoding
+	c.compressAt = threshold
+}
+
+// log returns the logger in use by this client.
+func (c *Client) log() Logger {
+	if c == nil {
+		return defaultLogger
+	}
+	if c.logger != nil {
+		return c.logger
+	}
+	if c.debug {
+		return debugLogger
+	}
+	return defaultLogger
+}
+
+// SetPingRate sets the interval between health checks of each session.  Call before Dial.
+func (c *Client) SetPingRate(rate time.Duration) {
+	c.pingRate = rate
+}
+
+// SetCircuitBreaker sets when nodes are pulled out of rotation.  Call before Dial.
+//
+// After Threshold consecutive failures a node stops handing out sessions.  Once Cooldown
+// has passed the next health check probes the node and puts it back in rotation on success.
+func (c *Client) SetCircuitBreaker(breaker CircuitBreaker) {
+	c.breaker = breaker
+}
+
+// SetBalancer sets the strategy used to spread sessions across nodes.  Defaults to round-robin.
+func (c *Client) SetBalancer(balancer Balancer) {
+	c.balancer = balancer
+}
+
+// NodeStatus returns a health snapshot for every node in the cluster.
+func (c *Client) NodeStatus() []NodeStatus {
+	nodes := c.nodeList()
+	out := make([]NodeStatus, len(nodes))
+	for i, n := range nodes {
+		out[i] = n.Status()
+	}
+	return out
+}
+
 // Dial connects the client to all the nodes in the cluster.
 // Nodes which are down at startup will attempt to dial later.
 // If all nodes are down an error will be thrown.
 func (c *Client) Dial() error {
+	if c.closed() {
+		return ErrClientClosed
+	}
+	down, total := 0, 0
+	for _, n := range c.nodeList() {
+		down += c.dialNode(n)
+		total += n.sessions
+	}
+	if down == total {
+		return ErrAllNodesDown
+	}
+	go c.check()
+	return nil
+}
+
+// dialNode dials every session of node n and returns how many failed.
+func (c *Client) dialNode(n *Node) int {
 	down := 0
-	for i := 0; i < len(c.cluster); i++ {
-		s := <-c.cluster
-		s.debug = c.debug
+	for i := 0; i < len(n.pool); i++ {
+		s := <-n.pool
 		if err := s.Dial(); err != nil {
 			down++
-			if c.debug {
-				log.Print(err.Error())
-			}
 		}
-		c.cluster <- s
+		n.pool <- s
+	}
+	return down
+}
+
+// nodeList returns a snapshot of the nodes currently in the cluster.
+func (c *Client) nodeList() []*Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*Node(nil), c.nodes...)
+}
+
+// AddNode adds the Riak node at addr to the cluster with conns connections.
+//
+// The node is added even if it cannot be dialed yet, in which case it is redialed by the
+// regular health checks and ErrAllNodesDown is returned for information.
+func (c *Client) AddNode(addr string, conns int) error {
+	if c.closed() {
+		return ErrClientClosed
+	}
+	if conns < 1 {
+		return ErrInvalidConns
+	}
+	if c.hasNode(addr) {
+		return ErrNodeExists
+	}
+	c.log().Log(LOG_INFO, "adding node", Field{"node", addr})
+	// dial before the node is visible to the rest of the client
+	n := newNode(addr, conns, c)
+	down := c.dialNode(n)
+
+	c.mu.Lock()
+	var err error
+	switch {
+	case c.closed():
+		err = ErrClientClosed
+	case c.hasNodeLocked(addr):
+		err = ErrNodeExists
+	default:
+		c.nodes = append(c.nodes, n)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		for _, s := range n.all {
+			s.Close()
+		}
+		return err
 	}
-	if down == len(c.cluster) {
+	if down == conns {
 		return ErrAllNodesDown
 	}
-	go c.check()
 	return nil
 }
 
+// hasNode reports whether the node at addr is part of the cluster.
+func (c *Client) hasNode(addr string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hasNodeLocked(addr)
+}
+
+// hasNodeLocked is hasNode for callers already holding mu.
+func (c *Client) hasNodeLocked(addr string) bool {
+	for _, n := range c.nodes {
+		if n.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveNode takes the Riak node at addr out of the cluster.
+//
+// No new sessions are handed out for the node, even to callers already waiting on it.
+// Idle sessions are closed right away and sessions which are checked out are closed as
+// they are released.
+func (c *Client) RemoveNode(addr string) error {
+	c.mu.Lock()
+	var node *Node
+	for i, n := range c.nodes {
+		if n.addr == addr {
+			node = n
+			c.nodes = append(c.nodes[:i:i], c.nodes[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+	if node == nil {
+		return ErrNodeNotFound
+	}
+	node.remove()
+	c.mu.Lock()
+	c.draining[node] = true
+	c.mu.Unlock()
+
+	c.log().Log(LOG_INFO, "draining node", Field{"node", addr})
+	go func() {
+		// a drain cut short by shutdown is finished by Shutdown
+		if node.drain(c.shutdown) {
+			c.mu.Lock()
+			delete(c.draining, node)
+			c.mu.Unlock()
+			c.log().Log(LOG_INFO, "drained node", Field{"node", addr})
+		}
+	}()
+	return nil
+}
+
+// SyncNodes makes the cluster match addrs, adding missing nodes with the connection count
+// given to NewClient and draining nodes which are no longer listed.
+//
+// An empty addrs is ignored and ErrNoNodes returned, so a failed lookup cannot remove every node.
+func (c *Client) SyncNodes(addrs []string) error {
+	if c.closed() {
+		return ErrClientClosed
+	}
+	if len(addrs) == 0 {
+		return ErrNoNodes
+	}
+	want := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		want[addr] = true
+	}
+	have := make(map[string]bool)
+	for _, n := range c.nodeList() {
+		have[n.addr] = true
+		if !want[n.addr] {
+			c.RemoveNode(n.addr)
+		}
+	}
+	var err error
+	for _, addr := range addrs {
+		if !have[addr] {
+			if e := c.AddNode(addr, c.conns); e != nil && e != ErrNodeExists {
+				err = e
+			}
+			have[addr] = true
+		}
+	}
+	return err
+}
+
+// Discover runs d every interval and syncs the cluster membership with the result
+// until the client is closed.
+func (c *Client) Discover(d Discovery, interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				addrs, err := d()
+				if err == nil {
+					err = c.SyncNodes(addrs)
+				}
+				if err != nil {
+					c.log().Log(LOG_WARN, "discovery failed", Field{"error", err})
+				}
+			case <-c.shutdown:
+				return
+			}
+		}
+	}()
+}
+
 // check runs a Riak Ping on each connection, and redials if the connection was lost.
 func (c *Client) check() {
 	for {
 		select {
-		case <-time.After(PingRate):
-			for i := 0; i < len(c.cluster); i++ {
-				go func() {
-					s := <-c.cluster
-					s.active = s.Ping()
-					if !s.Available() {
-						s.check()
-					}
-					c.cluster <- s
-				}()
+		case <-time.After(c.pingRate):
+			for _, n := range c.nodeList() {
+				for i := 0; i < len(n.pool); i++ {
+					go func(n *Node) {
+						var s *Session
+						select {
+						case s = <-n.pool:
+						default:
+							// checked out, or the node is being drained
+							return
+						}
+						if !n.probe() {
+							// breaker is open, wait for the cooldown
+							n.pool <- s
+							return
+						}
+						s.active = s.Ping()
+						if !s.Available() {
+							s.check()
+						}
+						n.pool <- s
+					}(n)
+				}
 			}
 		case <-c.shutdown:
 			return
@@ -78,30 +402,174 @@ func (c *Client) check() {
 	}
 }
 
-// Close gracefully shuts down all the node connections.
+// ShutdownSummary reports what happened to the sessions during Shutdown.
+type ShutdownSummary struct {
+	Sessions    int // total number of sessions
+	Released    int // sessions which were idle or released in time and closed gracefully
+	ForceClosed int // sessions still checked out when the context expired
+}
+
+// closed reports whether the client has been shut down.
+func (c *Client) closed() bool {
+	select {
+	case <-c.shutdown:
+		return true
+	default:
+	}
+	return false
+}
+
+// Shutdown stops handing out sessions and waits for every checked out session to be released
+// before closing it.  Once ctx expires any sessions still checked out are force closed and
+// ctx.Err() is returned along with the summary.
+//
+// After Shutdown operations on the client and its sessions return ErrClientClosed.
+func (c *Client) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	first := false
+	c.once.Do(func() {
+		close(c.shutdown)
+		first = true
+	})
+	if !first {
+		return ShutdownSummary{}, ErrClientClosed
+	}
+
+	var sum ShutdownSummary
+	var err error
+	for _, n := range c.allNodes() {
+		if n.isRemoved() {
+			// take over from the drain, which stops on shutdown
+			<-n.drainDone
+		}
+		sum.Sessions += n.sessions
+	drain:
+		for n.drainedCount() < n.sessions {
+			var s *Session
+			select {
+			case s = <-n.pool:
+			default:
+				if err != nil {
+					// past the deadline only idle sessions are closed
+					break drain
+				}
+				select {
+				case s = <-n.pool:
+				case <-ctx.Done():
+					err = ctx.Err()
+					continue
+				}
+			}
+			n.closeSession(s)
+		}
+		sum.Released += n.drainedCount()
+		for _, s := range n.all {
+			if !n.isDrained(s) {
+				c.log().Log(LOG_WARN, "force closing session", Field{"node", n.addr})
+				s.forceClose()
+				sum.ForceClosed++
+			}
+		}
+		n.close()
+	}
+	return sum, err
+}
+
+// allNodes returns the nodes in the cluster along with removed nodes which are still draining.
+func (c *Client) allNodes() []*Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nodes := append([]*Node(nil), c.nodes...)
+	for n := range c.draining {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// Close gracefully shuts down all the node connections without waiting.  Idle sessions are
+// closed right away and checked out sessions as they are released.  Use Shutdown to wait
+// for them.
 func (c *Client) Close() {
-	c.shutdown <- true
-	for i := 0; i < len(c.cluster); i++ {
-		s := <-c.cluster
-		s.Close()
-		c.cluster <- s
+	c.once.Do(func() {
+		close(c.shutdown)
+	})
+	for _, n := range c.allNodes() {
+		n.close()
+	idle:
+		for {
+			select {
+			case s := <-n.pool:
+				n.closeSession(s)
+			default:
+				break idle
+			}
+		}
 	}
 }
 
-// Session returns a new session.
+// Session returns a new session from the node picked by the balancer.
+//
+// Returns nil if no node has an available session or the client has been shut down.
 func (c *Client) Session() *Session {
-	count := len(c.cluster)
-	for {
-		s := <-c.cluster
-		if s.Available() {
-			return s
-		}
-		c.cluster <- s
+	return c.SessionOn("")
+}
 
-		count--
-		if count == 0 {
-			break
+// SessionOn returns a new session, preferring the node at addr if it is in rotation.
+//
+// The preferred node is passed to the balancer as a hint, which falls back to its
+// normal strategy if that node cannot be used.
+func (c *Client) SessionOn(addr string) *Session {
+	return c.session(addr, true)
+}
+
+// session returns a new session like SessionOn.  Unless wait is set only idle sessions are taken.
+func (c *Client) session(addr string, wait bool) *Session {
+	if c.closed() {
+		return nil
+	}
+	start := time.Now()
+	var nodes []*Node
+	for _, n := range c.nodeList() {
+		if n.allow() {
+			nodes = append(nodes, n)
+		}
+	}
+	// First only take idle sessions, then wait on busy nodes.
+	phases := []bool{false}
+	if wait {
+		phases = append(phases, true)
+	}
+	for _, wait := range phases {
+		candidates := append([]*Node(nil), nodes...)
+		for len(candidates) > 0 {
+			n := c.balancer.Pick(candidates, addr)
+			if n == nil {
+				break
+			}
+			// a removed node is dropped like one without sessions
+			s, err := n.take(wait)
+			if err == ErrClientClosed {
+				return nil
+			}
+			if s != nil {
+				c.observePool(PoolEvent{Type: POOL_CHECKOUT, Node: n.addr, Wait: time.Since(start)})
+				return s
+			}
+			for i, cn := range candidates {
+				if cn == n {
+					candidates = append(candidates[:i], candidates[i+1:]...)
+					break
+				}
+			}
 		}
 	}
 	return nil
 }
+
+// size returns the total number of sessions across all nodes.
+func (c *Client) size() int {
+	size := 0
+	for _, n := range c.nodeList() {
+		size += n.sessions
+	}
+	return size
+}
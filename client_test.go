@@ -1,8 +1,15 @@
 package riaken_core
 
 import (
+	"bytes"
+	"context"
 	"log"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
 )
 
 var client *Client
@@ -131,3 +138,334 @@ func TestClientServerInfo(t *testing.T) {
 	t.Log(string(info.GetNode()))
 	t.Log(string(info.GetServerVersion()))
 }
+
+func TestClientNodeStatus(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	client.SetPingRate(time.Millisecond * 10)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	session := client.Session()
+	if !session.Ping() {
+		t.Error("no ping response")
+	}
+	session.Release()
+
+	status := client.NodeStatus()
+	if len(status) != 1 {
+		t.Fatalf("expected: %d, got: %d", 1, len(status))
+	}
+	if status[0].Addr != addr || status[0].Sessions != 2 {
+		t.Errorf("unexpected status: %+v", status[0])
+	}
+	if status[0].State != BREAKER_CLOSED || status[0].Latency == 0 {
+		t.Errorf("unexpected status: %+v", status[0])
+	}
+}
+
+func TestClientAddRemoveNode(t *testing.T) {
+	addr1, stop1 := fakeServer(t, fakeEcho)
+	defer stop1()
+	addr2, stop2 := fakeServer(t, fakeEcho)
+	defer stop2()
+	client := NewClient([]string{addr1}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	if err := client.AddNode(addr2, 3); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := client.AddNode(addr2, 3); err != ErrNodeExists {
+		t.Errorf("expected: %v, got: %v", ErrNodeExists, err)
+	}
+	if err := client.AddNode("127.0.0.1:1", -1); err != ErrInvalidConns {
+		t.Errorf("expected: %v, got: %v", ErrInvalidConns, err)
+	}
+	if len(client.NodeStatus()) != 2 {
+		t.Fatalf("expected: %d, got: %d", 2, len(client.NodeStatus()))
+	}
+
+	// hold a session on the node being removed
+	held := client.SessionOn(addr1)
+	if held.addr != addr1 {
+		t.Fatalf("expected: %s, got: %s", addr1, held.addr)
+	}
+	if err := client.RemoveNode(addr1); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := client.RemoveNode(addr1); err != ErrNodeNotFound {
+		t.Errorf("expected: %v, got: %v", ErrNodeNotFound, err)
+	}
+	for i := 0; i < 3; i++ {
+		s := client.Session()
+		if s.addr != addr2 {
+			t.Errorf("expected: %s, got: %s", addr2, s.addr)
+		}
+		defer s.Release()
+	}
+
+	// the held session keeps working until released, then it is closed by the drain
+	if !held.Ping() {
+		t.Error("expected held session to remain usable")
+	}
+	held.Release()
+}
+
+func TestClientRemoveNodeWaiting(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	// wait on the only session of a node snapshot taken before the removal
+	node := client.nodeList()[0]
+	held := client.Session()
+	result := make(chan error, 1)
+	go func() {
+		_, err := node.take(true)
+		result <- err
+	}()
+	if err := client.RemoveNode(addr); err != nil {
+		t.Fatal(err.Error())
+	}
+	select {
+	case err := <-result:
+		if err != ErrNodeRemoved {
+			t.Errorf("expected: %v, got: %v", ErrNodeRemoved, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("take blocked on a removed node")
+	}
+	held.Release()
+}
+
+func TestClientSyncNodes(t *testing.T) {
+	addr1, stop1 := fakeServer(t, fakeEcho)
+	defer stop1()
+	addr2, stop2 := fakeServer(t, fakeEcho)
+	defer stop2()
+	client := NewClient([]string{addr1}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	if err := client.SyncNodes(nil); err != ErrNoNodes {
+		t.Errorf("expected: %v, got: %v", ErrNoNodes, err)
+	}
+	client.Discover(StaticDiscovery(addr2), time.Millisecond*10)
+	for i := 0; i < 100; i++ {
+		if st := client.NodeStatus(); len(st) == 1 && st[0].Addr == addr2 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Errorf("expected only %s, got: %+v", addr2, client.NodeStatus())
+}
+
+func TestClientShutdown(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	held := client.Session()
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		held.Release()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sum, err := client.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if sum.Sessions != 2 || sum.Released != 2 || sum.ForceClosed != 0 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+
+	if _, err := client.Shutdown(ctx); err != ErrClientClosed {
+		t.Errorf("expected: %v, got: %v", ErrClientClosed, err)
+	}
+	if client.Session() != nil {
+		t.Error("expected no sessions after shutdown")
+	}
+	if err := client.Dial(); err != ErrClientClosed {
+		t.Errorf("expected: %v, got: %v", ErrClientClosed, err)
+	}
+	client.Close()
+}
+
+func TestClientShutdownForce(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	held := client.Session()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	sum, err := client.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if sum.Released != 1 || sum.ForceClosed != 1 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	if _, err := held.ServerInfo(); err != ErrClientClosed {
+		t.Errorf("expected: %v, got: %v", ErrClientClosed, err)
+	}
+	held.Release()
+}
+
+func TestClientShutdownWaiting(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	held := client.Session()
+	waiter := make(chan *Session, 1)
+	go func() {
+		waiter <- client.Session()
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	result := make(chan ShutdownSummary, 1)
+	go func() {
+		sum, _ := client.Shutdown(context.Background())
+		result <- sum
+	}()
+	// the waiter gives up on shutdown instead of waiting for the held session
+	select {
+	case s := <-waiter:
+		if s != nil {
+			t.Error("expected no session after shutdown")
+			s.Release()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked after shutdown")
+	}
+	held.Release()
+	if sum := <-result; sum.Released != 1 || sum.ForceClosed != 0 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+}
+
+func TestClientShutdownNodes(t *testing.T) {
+	addr1, stop1 := fakeServer(t, fakeEcho)
+	defer stop1()
+	addr2, stop2 := fakeServer(t, fakeEcho)
+	defer stop2()
+	addr3, stop3 := fakeServer(t, fakeEcho)
+	defer stop3()
+	client := NewClient([]string{addr1, addr2, addr3}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// one session held on the first node, and one on a removed node still draining
+	held := client.SessionOn(addr1)
+	removed := client.SessionOn(addr3)
+	if held.addr != addr1 || removed.addr != addr3 {
+		t.Fatalf("unexpected sessions: %s, %s", held.addr, removed.addr)
+	}
+	if err := client.RemoveNode(addr3); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	sum, err := client.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	// idle sessions on the nodes after the first are still closed gracefully
+	if sum.Sessions != 6 || sum.Released != 4 || sum.ForceClosed != 2 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	held.Release()
+	removed.Release()
+}
+
+func TestClientClose(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	held := client.Session()
+	client.Close()
+	if !held.Available() {
+		t.Error("expected checked out session to stay connected")
+	}
+	held.Release()
+	if held.Available() {
+		t.Error("expected released session to be closed")
+	}
+}
+
+func TestClientIdStrategy(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		if code == Messages["SetClientIdReq"] {
+			req := &rpb.RpbSetClientIdReq{}
+			proto.Unmarshal(in, req)
+			mu.Lock()
+			ids = append(ids, string(req.GetClientId()))
+			mu.Unlock()
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+
+	client := NewClient([]string{addr}, 2)
+	client.SetClientId(RandomClientId())
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	status := client.NodeStatus()[0]
+	if len(status.ClientIds) != 2 || len(status.ClientIds[0]) != 4 || bytes.Equal(status.ClientIds[0], status.ClientIds[1]) {
+		t.Errorf("expected 2 distinct client ids, got: %v", status.ClientIds)
+	}
+
+	// a redial sets a new id
+	session := client.Session()
+	session.forceClose()
+	session.active = false
+	session.check()
+	session.Release()
+	mu.Lock()
+	if len(ids) != 3 {
+		t.Errorf("expected: 3 client ids set, got: %d", len(ids))
+	}
+	mu.Unlock()
+
+	fixed := NewClient([]string{addr}, 1)
+	fixed.SetClientId(FixedClientId([]byte("app1")))
+	if err := fixed.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fixed.Close()
+	if id := fixed.NodeStatus()[0].ClientIds[0]; string(id) != "app1" {
+		t.Errorf("expected: app1, got: %s", id)
+	}
+}
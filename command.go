@@ -0,0 +1,78 @@
+package riaken_core
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrUnexpectedResponse error = errors.New("unexpected response code")
+
+// Command is a single request/response exchange with Riak, run by Session.Execute.
+//
+// Implementing Command allows sending requests riaken does not know about, such as newer
+// KV or Riak TS calls, by their opcode.
+type Command interface {
+	RequestCode() byte                  // opcode of the request
+	ResponseCode() byte                 // opcode of the expected response
+	Marshal(buf []byte) ([]byte, error) // appends the request body to buf
+	Decode(data []byte) error           // decodes the response body, which is only valid during the call
+}
+
+// ProtoCommand is a Command for requests and responses which are protocol buffer messages.
+type ProtoCommand struct {
+	Code     byte          // request opcode
+	Req      proto.Message // request, nil for an empty body
+	RespCode byte          // expected response opcode
+	Resp     proto.Message // decoded response, nil if the response has no body
+}
+
+func (c *ProtoCommand) RequestCode() byte {
+	return c.Code
+}
+
+func (c *ProtoCommand) ResponseCode() byte {
+	return c.RespCode
+}
+
+func (c *ProtoCommand) Marshal(buf []byte) ([]byte, error) {
+	if c.Req == nil {
+		return buf, nil
+	}
+	pb := proto.NewBuffer(buf)
+	err := pb.Marshal(c.Req)
+	return pb.Bytes(), err
+}
+
+func (c *ProtoCommand) Decode(data []byte) error {
+	if c.Resp == nil {
+		return nil
+	}
+	return proto.Unmarshal(data, c.Resp)
+}
+
+// rpbCommand is a ProtoCommand whose response is decoded by rpbDecode.
+type rpbCommand struct {
+	ProtoCommand
+	out interface{} // decoded response
+}
+
+func (c *rpbCommand) Decode(data []byte) (err error) {
+	c.out, err = rpbDecode(c.RespCode, data)
+	return err
+}
+
+// decode hands the response frame resp to cmd, or returns the Riak error it holds.
+func decode(resp []byte, cmd Command) error {
+	if len(resp) == 0 {
+		return ErrZeroLength
+	}
+	switch resp[0] {
+	case cmd.ResponseCode():
+		return cmd.Decode(resp[1:])
+	case Messages["ErrorResp"]:
+		_, err := rpbRead(resp)
+		return err
+	}
+	return ErrUnexpectedResponse
+}
@@ -0,0 +1,73 @@
+package riaken_core
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// echoCommand sends a raw body with a custom opcode and keeps the response body.
+type echoCommand struct {
+	in  []byte
+	out []byte
+}
+
+func (c *echoCommand) RequestCode() byte  { return 100 }
+func (c *echoCommand) ResponseCode() byte { return 101 }
+
+func (c *echoCommand) Marshal(buf []byte) ([]byte, error) {
+	return append(buf, c.in...), nil
+}
+
+func (c *echoCommand) Decode(data []byte) error {
+	c.out = append([]byte(nil), data...)
+	return nil
+}
+
+func TestSessionExecute(t *testing.T) {
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case 100:
+			return 101, in
+		case 102:
+			out, _ := proto.Marshal(&rpb.RpbErrorResp{Errmsg: []byte("unknown message code"), Errcode: proto.Uint32(1)})
+			return Messages["ErrorResp"], out
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+
+	cmd := &echoCommand{in: []byte("custom")}
+	if err := session.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(cmd.out) != "custom" {
+		t.Errorf("expected: custom, got: %s", cmd.out)
+	}
+
+	info := new(rpb.RpbGetServerInfoResp)
+	err := session.Execute(&ProtoCommand{
+		Code:     Messages["GetServerInfoReq"],
+		RespCode: Messages["GetServerInfoResp"],
+		Resp:     info,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(info.GetServerVersion()) != "2.1.4" {
+		t.Errorf("expected: 2.1.4, got: %s", info.GetServerVersion())
+	}
+
+	err = session.Execute(&ProtoCommand{Code: 102, RespCode: 103})
+	if err == nil || err.Error() != "riak error [1]: unknown message code" {
+		t.Errorf("expected a riak error, got: %v", err)
+	}
+	err = session.Execute(&ProtoCommand{Code: Messages["PingReq"], RespCode: Messages["GetResp"]})
+	if err != ErrUnexpectedResponse {
+		t.Errorf("expected: %v, got: %v", ErrUnexpectedResponse, err)
+	}
+	if session.Available() {
+		t.Error("expected the connection to be marked inactive")
+	}
+}
@@ -0,0 +1,119 @@
+package riaken_core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// Compressor compresses object values for a content encoding such as gzip.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var ErrDecompressedSize error = errors.New("decompressed value exceeds the size limit")
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip": GzipCompressor{},
+	}
+)
+
+// RegisterCompressor makes c available for the content encoding named encoding, for example
+// to add zstd or snappy.  gzip is registered by default.
+func RegisterCompressor(encoding string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[encoding] = c
+}
+
+// compressor returns the Compressor registered for encoding, or nil.
+func compressor(encoding string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[encoding]
+}
+
+// GzipCompressor compresses with compress/gzip at the default level.  Decompress fails with
+// ErrDecompressedSize for values larger than Limit, or MaxFrameSize if Limit is 0.
+type GzipCompressor struct {
+	Limit int
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	limit := g.Limit
+	if limit <= 0 {
+		limit = MaxFrameSize
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrDecompressedSize
+	}
+	return out, nil
+}
+
+// compress compresses the value of c if the client has compression set, the value is
+// at least the threshold and no content encoding was set by the caller.
+func (c *Client) compress(content *rpb.RpbContent) error {
+	if c == nil || c.compression == "" || content.ContentEncoding != nil || len(content.Value) < c.compressAt {
+		return nil
+	}
+	comp := compressor(c.compression)
+	if comp == nil {
+		return nil
+	}
+	data, err := comp.Compress(content.Value)
+	if err != nil {
+		return err
+	}
+	content.Value = data
+	content.ContentEncoding = []byte(c.compression)
+	return nil
+}
+
+// decompress replaces compressed values in contents with the original data and clears
+// their content encoding.  Values with an unknown encoding are left as is.
+func decompress(contents []*rpb.RpbContent) error {
+	for _, content := range contents {
+		if content.ContentEncoding == nil || len(content.Value) == 0 {
+			continue
+		}
+		comp := compressor(string(content.ContentEncoding))
+		if comp == nil {
+			continue
+		}
+		data, err := comp.Decompress(content.Value)
+		if err != nil {
+			return err
+		}
+		content.Value = data
+		content.ContentEncoding = nil
+	}
+	return nil
+}
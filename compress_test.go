@@ -0,0 +1,123 @@
+package riaken_core
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// reverseCompressor is a Compressor which reverses the data, to check the registry.
+type reverseCompressor struct{}
+
+func (reverseCompressor) reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func (r reverseCompressor) Compress(data []byte) ([]byte, error) {
+	return r.reverse(data), nil
+}
+
+func (r reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return r.reverse(data), nil
+}
+
+func TestObjectCompression(t *testing.T) {
+	var mu sync.Mutex
+	var stored *rpb.RpbContent
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch code {
+		case Messages["PutReq"]:
+			req := &rpb.RpbPutReq{}
+			proto.Unmarshal(in, req)
+			stored = req.GetContent()
+			return Messages["PutResp"], nil
+		case Messages["GetReq"]:
+			out, _ := proto.Marshal(&rpb.RpbGetResp{Content: []*rpb.RpbContent{stored}})
+			return Messages["GetResp"], out
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	client.SetCompression("gzip", 100)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	object := session.GetBucket("b1").Object("o1")
+	large := bytes.Repeat([]byte(`{"field":"value"}`), 100)
+	for _, data := range [][]byte{[]byte("small"), large} {
+		if _, err := object.Store(data); err != nil {
+			t.Fatal(err.Error())
+		}
+		mu.Lock()
+		encoding, size := string(stored.GetContentEncoding()), len(stored.GetValue())
+		mu.Unlock()
+		if len(data) < 100 && encoding != "" {
+			t.Errorf("expected no encoding for a small value, got: %s", encoding)
+		}
+		if len(data) >= 100 && (encoding != "gzip" || size >= len(data)) {
+			t.Errorf("expected a gzip value, got: %s of %d bytes", encoding, size)
+		}
+		res, err := object.Fetch()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		c := res.GetContent()[0]
+		if !bytes.Equal(c.GetValue(), data) || c.ContentEncoding != nil {
+			t.Errorf("value was not decompressed: %q", c.GetValue())
+		}
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	RegisterCompressor("reverse", reverseCompressor{})
+	contents := []*rpb.RpbContent{
+		{Value: []byte("cba"), ContentEncoding: []byte("reverse")},
+		{Value: []byte("zyx"), ContentEncoding: []byte("unknown")},
+	}
+	if err := decompress(contents); err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents[0].GetValue()) != "abc" || contents[0].ContentEncoding != nil {
+		t.Errorf("unexpected content: %v", contents[0])
+	}
+	if string(contents[1].GetValue()) != "zyx" || string(contents[1].GetContentEncoding()) != "unknown" {
+		t.Errorf("unknown encodings should be left as is: %v", contents[1])
+	}
+
+	content := &rpb.RpbContent{Value: []byte("abc")}
+	c := &Client{compression: "reverse"}
+	if err := c.compress(content); err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(content.GetValue()) != "cba" || string(content.GetContentEncoding()) != "reverse" {
+		t.Errorf("unexpected content: %v", content)
+	}
+}
+
+func TestGzipDecompressLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 1000)
+	packed, err := GzipCompressor{}.Compress(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if out, err := (GzipCompressor{Limit: 1000}).Decompress(packed); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("expected the value back, got: %d bytes, %v", len(out), err)
+	}
+	if _, err := (GzipCompressor{Limit: 999}).Decompress(packed); err != ErrDecompressedSize {
+		t.Errorf("expected: %v, got: %v", ErrDecompressedSize, err)
+	}
+}
@@ -0,0 +1,96 @@
+package riaken_core
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// ConnConfig tunes the connections to a node.  The zero value leaves everything to the
+// system defaults, without timeouts.
+//
+// Timeouts apply whatever the Dialer, while the TCP options are only used by the default one.
+type ConnConfig struct {
+	DialTimeout  time.Duration // limit on opening a connection
+	ReadTimeout  time.Duration // limit on reading each response frame
+	WriteTimeout time.Duration // limit on writing each request, or each pipeline as a whole
+	KeepAlive    time.Duration // TCP keepalive period, 0 for the Go default and negative to disable
+	Nagle        bool          // delay small writes, which Go turns off by default
+	ReadBuffer   int           // socket receive buffer size in bytes, 0 for the system default
+	WriteBuffer  int           // socket send buffer size in bytes, 0 for the system default
+}
+
+// ClientConfig holds the connection settings of a client.
+type ClientConfig struct {
+	ConnConfig                       // settings for every node
+	Nodes      map[string]ConnConfig // settings replacing the above for the node at each address
+}
+
+// Dial opens a TCP connection to addr with these settings.  It can be used as a Dialer.
+func (c ConnConfig) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout:   c.DialTimeout,
+		KeepAlive: c.KeepAlive,
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := c.tune(tcp); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// tune applies the TCP options to conn.
+func (c ConnConfig) tune(conn *net.TCPConn) error {
+	if c.Nagle {
+		if err := conn.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if c.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(c.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if c.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(c.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connConfig returns the connection settings for the node at addr.
+func (c *Client) connConfig(addr string) ConnConfig {
+	if c == nil {
+		return ConnConfig{}
+	}
+	if n, ok := c.config.Nodes[addr]; ok {
+		return n
+	}
+	return c.config.ConnConfig
+}
+
+// config returns the connection settings of this session.
+func (s *Session) config() ConnConfig {
+	return s.client().connConfig(s.addr)
+}
+
+// setReadDeadline limits the next read by the read timeout, if any.
+func (s *Session) setReadDeadline() {
+	if t := s.config().ReadTimeout; t > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(t))
+	}
+}
+
+// setWriteDeadline limits the next write by the write timeout, if any.
+func (s *Session) setWriteDeadline() {
+	if t := s.config().WriteTimeout; t > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(t))
+	}
+}
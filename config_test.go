@@ -0,0 +1,81 @@
+package riaken_core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestClientConfigNodes(t *testing.T) {
+	client := NewClientConfig([]string{"node1", "node2"}, 0, ClientConfig{
+		ConnConfig: ConnConfig{ReadTimeout: time.Second},
+		Nodes: map[string]ConnConfig{
+			"node2": {ReadTimeout: time.Minute},
+		},
+	})
+	if c := client.connConfig("node1"); c.ReadTimeout != time.Second {
+		t.Errorf("expected: %v, got: %v", time.Second, c.ReadTimeout)
+	}
+	if c := client.connConfig("node2"); c.ReadTimeout != time.Minute {
+		t.Errorf("expected: %v, got: %v", time.Minute, c.ReadTimeout)
+	}
+}
+
+func TestClientConfigReadTimeout(t *testing.T) {
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		if code == Messages["GetReq"] {
+			time.Sleep(200 * time.Millisecond)
+			out, _ := proto.Marshal(&rpb.RpbGetResp{})
+			return Messages["GetResp"], out
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+	client := NewClientConfig([]string{addr}, 1, ClientConfig{
+		ConnConfig: ConnConfig{
+			ReadTimeout: 50 * time.Millisecond,
+			Nagle:       true,
+			ReadBuffer:  64 << 10,
+			WriteBuffer: 64 << 10,
+		},
+	})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	if !session.Ping() {
+		t.Error("no ping response")
+	}
+	_, err := session.GetBucket("b1").Object("o1").Fetch()
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("expected a timeout, got: %v", err)
+	}
+	if session.Available() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestClientConfigDialTimeout(t *testing.T) {
+	client := NewClientConfig([]string{"node1"}, 1, ClientConfig{
+		ConnConfig: ConnConfig{DialTimeout: 50 * time.Millisecond},
+	})
+	client.SetDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	start := time.Now()
+	if err := client.Dial(); err == nil {
+		client.Close()
+		t.Error("expected dial to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial took %v", elapsed)
+	}
+}
@@ -28,7 +28,9 @@ func (c *Counter) Do(opts interface{}) *Counter {
 }
 
 // Update a counter.
-func (c *Counter) Update(count int64) (*rpb.RpbCounterUpdateResp, error) {
+func (c *Counter) Update(count int64) (_ *rpb.RpbCounterUpdateResp, err error) {
+	sp := c.bucket.session.trace("CounterUpdate", string(c.bucket.btype), c.bucket.name, c.key)
+	defer sp.end(&err)
 	defer c.reset()
 	opts := new(rpb.RpbCounterUpdateReq)
 	if c.opts != nil {
@@ -41,19 +43,22 @@ func (c *Counter) Update(count int64) (*rpb.RpbCounterUpdateResp, error) {
 	opts.Bucket = []byte(c.bucket.name)
 	opts.Key = []byte(c.key)
 	opts.Amount = proto.Int64(count)
-	in, err := proto.Marshal(opts)
-	if err != nil {
+	out := new(rpb.RpbCounterUpdateResp)
+	if err := c.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["CounterUpdateReq"],
+		Req:      opts,
+		RespCode: Messages["CounterUpdateResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	out, err := c.bucket.session.execute(Messages["CounterUpdateReq"], in)
-	if err != nil {
-		return nil, err
-	}
-	return out.(*rpb.RpbCounterUpdateResp), nil
+	return out, nil
 }
 
 // Get a counter.
-func (c *Counter) Get() (*rpb.RpbCounterGetResp, error) {
+func (c *Counter) Get() (_ *rpb.RpbCounterGetResp, err error) {
+	sp := c.bucket.session.trace("CounterGet", string(c.bucket.btype), c.bucket.name, c.key)
+	defer sp.end(&err)
 	defer c.reset()
 	opts := new(rpb.RpbCounterGetReq)
 	if c.opts != nil {
@@ -65,13 +70,14 @@ func (c *Counter) Get() (*rpb.RpbCounterGetResp, error) {
 	}
 	opts.Bucket = []byte(c.bucket.name)
 	opts.Key = []byte(c.key)
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return nil, err
-	}
-	out, err := c.bucket.session.execute(Messages["CounterGetReq"], in)
-	if err != nil {
+	out := new(rpb.RpbCounterGetResp)
+	if err := c.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["CounterGetReq"],
+		Req:      opts,
+		RespCode: Messages["CounterGetResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	return out.(*rpb.RpbCounterGetResp), nil
+	return out, nil
 }
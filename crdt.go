@@ -371,7 +371,9 @@ func (dt *Crdt) processMap(res []*rpb.MapEntry) {
 }
 
 // Fetch returns the data for this object at key.
-func (dt *Crdt) Fetch() (*rpb.DtFetchResp, error) {
+func (dt *Crdt) Fetch() (_ *rpb.DtFetchResp, err error) {
+	sp := dt.bucket.session.trace("DtFetch", string(dt.bucket.btype), dt.bucket.name, dt.key)
+	defer sp.end(&err)
 	defer dt.reset()
 	opts := new(rpb.DtFetchReq)
 	if dt.opts != nil {
@@ -386,23 +388,45 @@ func (dt *Crdt) Fetch() (*rpb.DtFetchResp, error) {
 	if opts.Type == nil {
 		opts.Type = dt.bucket.btype
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
+	out := new(rpb.DtFetchResp)
+	if err := dt.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["DtFetchReq"],
+		Req:      opts,
+		RespCode: Messages["DtFetchResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	out, err := dt.bucket.session.execute(Messages["DtFetchReq"], in)
+	dt.context = out.Context
+	dt.processCounter(out.GetValue().GetCounterValue())
+	dt.processSet(out.GetValue().GetSetValue())
+	dt.processMap(out.GetValue().GetMapValue())
+	return out, nil
+}
+
+// Update adds or replaces data for this object.
+func (dt *Crdt) Update() (_ *rpb.DtUpdateResp, err error) {
+	sp := dt.bucket.session.trace("DtUpdate", string(dt.bucket.btype), dt.bucket.name, dt.key)
+	defer sp.end(&err)
+	in, err := dt.updateReq()
 	if err != nil {
 		return nil, err
 	}
-	dt.context = out.(*rpb.DtFetchResp).Context
-	dt.processCounter(out.(*rpb.DtFetchResp).GetValue().GetCounterValue())
-	dt.processSet(out.(*rpb.DtFetchResp).GetValue().GetSetValue())
-	dt.processMap(out.(*rpb.DtFetchResp).GetValue().GetMapValue())
-	return out.(*rpb.DtFetchResp), nil
+	out := new(rpb.DtUpdateResp)
+	if err := dt.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["DtUpdateReq"],
+		Req:      in,
+		RespCode: Messages["DtUpdateResp"],
+		Resp:     out,
+	}); err != nil {
+		return nil, err
+	}
+	dt.processUpdate(out)
+	return out, nil
 }
 
-// Update adds or replaces data for this object.
-func (dt *Crdt) Update() (*rpb.DtUpdateResp, error) {
+// updateReq builds the DtUpdateReq for Update.
+func (dt *Crdt) updateReq() (*rpb.DtUpdateReq, error) {
 	defer dt.reset()
 	opts := new(rpb.DtUpdateReq)
 	if dt.opts != nil {
@@ -420,16 +444,12 @@ func (dt *Crdt) Update() (*rpb.DtUpdateResp, error) {
 	if opts.Context == nil {
 		opts.Context = dt.context
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return nil, err
-	}
-	out, err := dt.bucket.session.execute(Messages["DtUpdateReq"], in)
-	if err != nil {
-		return nil, err
-	}
-	dt.processCounter(out.(*rpb.DtUpdateResp).GetCounterValue())
-	dt.processSet(out.(*rpb.DtUpdateResp).GetSetValue())
-	dt.processMap(out.(*rpb.DtUpdateResp).GetMapValue())
-	return out.(*rpb.DtUpdateResp), nil
+	return opts, nil
+}
+
+// processUpdate registers the values returned by an update.
+func (dt *Crdt) processUpdate(res *rpb.DtUpdateResp) {
+	dt.processCounter(res.GetCounterValue())
+	dt.processSet(res.GetSetValue())
+	dt.processMap(res.GetMapValue())
 }
@@ -0,0 +1,329 @@
+package riaken_core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+var ErrCrdtStructPtr error = errors.New("FetchInto requires a non-nil pointer to a struct")
+var ErrCrdtStructMismatch error = errors.New("UpdateFrom requires prev and next to be the same struct type")
+
+// stringsType is the type set fields are converted to and from.
+var stringsType = reflect.TypeOf([]string(nil))
+
+// crdtField maps a single tagged struct field to a CRDT map field.
+type crdtField struct {
+	index int         // field index within the struct
+	name  string      // map field name
+	kind  CrdtMapType // map field type
+}
+
+// crdtFields parses the riak struct tags for struct type t.
+//
+// Tags take the form `riak:"name,type"` where type is one of flag, register, counter, set or map.
+// Fields without a riak tag, or tagged with "-", are ignored.
+func crdtFields(t reflect.Type) ([]crdtField, error) {
+	var fields []crdtField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("riak")
+		if tag == "" || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("field %s: riak tag must be of the form \"name,type\"", sf.Name)
+		}
+		f := crdtField{
+			index: i,
+			name:  parts[0],
+		}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		ft := sf.Type
+		switch parts[1] {
+		case "flag":
+			f.kind = CRDT_MAP_FLAG
+			if ft.Kind() != reflect.Bool {
+				return nil, fmt.Errorf("field %s: flag must be a bool", sf.Name)
+			}
+		case "register":
+			f.kind = CRDT_MAP_REGISTER
+			if ft.Kind() != reflect.String && !(ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Uint8) {
+				return nil, fmt.Errorf("field %s: register must be a string or []byte", sf.Name)
+			}
+		case "counter":
+			f.kind = CRDT_MAP_COUNTER
+			switch ft.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return nil, fmt.Errorf("field %s: counter must be a signed integer", sf.Name)
+			}
+		case "set":
+			f.kind = CRDT_MAP_SET
+			if ft.Kind() != reflect.Slice || !ft.ConvertibleTo(stringsType) {
+				return nil, fmt.Errorf("field %s: set must be a []string", sf.Name)
+			}
+		case "map":
+			f.kind = CRDT_MAP_MAP
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("field %s: map must be a struct or pointer to a struct", sf.Name)
+			}
+		default:
+			return nil, fmt.Errorf("field %s: unknown riak type %q", sf.Name, parts[1])
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// into copies the map values into the struct value rv.
+func (m *CrdtMap) into(rv reflect.Value) error {
+	fields, err := crdtFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		switch f.kind {
+		case CRDT_MAP_FLAG:
+			fv.SetBool(m.Flags[f.name])
+		case CRDT_MAP_REGISTER:
+			if fv.Kind() == reflect.String {
+				fv.SetString(m.Registers[f.name])
+			} else {
+				fv.SetBytes([]byte(m.Registers[f.name]))
+			}
+		case CRDT_MAP_COUNTER:
+			var value int64
+			if c, ok := m.Counters[f.name]; ok {
+				value = c.Value
+			}
+			fv.SetInt(value)
+		case CRDT_MAP_SET:
+			var values []string
+			if s, ok := m.Sets[f.name]; ok {
+				values = append(values, s.Values...)
+			}
+			fv.Set(reflect.ValueOf(values).Convert(fv.Type()))
+		case CRDT_MAP_MAP:
+			sub, ok := m.Maps[f.name]
+			if fv.Kind() == reflect.Ptr {
+				if !ok {
+					fv.Set(reflect.Zero(fv.Type()))
+					continue
+				}
+				fv.Set(reflect.New(fv.Type().Elem()))
+				fv = fv.Elem()
+			}
+			if !ok {
+				sub = m.crdt.NewMap()
+			}
+			if err := sub.into(fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// crdtDiff builds the minimal MapOp which turns struct value prev into struct value next.
+//
+// Returns nil if nothing changed.
+func crdtDiff(prev, next reflect.Value) (*rpb.MapOp, error) {
+	fields, err := crdtFields(next.Type())
+	if err != nil {
+		return nil, err
+	}
+	op := &rpb.MapOp{}
+	for _, f := range fields {
+		ov := prev.Field(f.index)
+		nv := next.Field(f.index)
+		field := &rpb.MapField{
+			Name: []byte(f.name),
+		}
+		switch f.kind {
+		case CRDT_MAP_FLAG:
+			if ov.Bool() == nv.Bool() {
+				continue
+			}
+			t := rpb.MapField_FLAG
+			field.Type = &t
+			o := rpb.MapUpdate_DISABLE
+			if nv.Bool() {
+				o = rpb.MapUpdate_ENABLE
+			}
+			op.Updates = append(op.Updates, &rpb.MapUpdate{Field: field, FlagOp: &o})
+		case CRDT_MAP_REGISTER:
+			var ob, nb []byte
+			if nv.Kind() == reflect.String {
+				ob, nb = []byte(ov.String()), []byte(nv.String())
+			} else {
+				ob, nb = ov.Bytes(), nv.Bytes()
+			}
+			if string(ob) == string(nb) {
+				continue
+			}
+			t := rpb.MapField_REGISTER
+			field.Type = &t
+			if len(nb) == 0 {
+				op.Removes = append(op.Removes, field)
+				continue
+			}
+			op.Updates = append(op.Updates, &rpb.MapUpdate{Field: field, RegisterOp: nb})
+		case CRDT_MAP_COUNTER:
+			delta := nv.Int() - ov.Int()
+			if delta == 0 {
+				continue
+			}
+			t := rpb.MapField_COUNTER
+			field.Type = &t
+			op.Updates = append(op.Updates, &rpb.MapUpdate{
+				Field:     field,
+				CounterOp: &rpb.CounterOp{Increment: proto.Int64(delta)},
+			})
+		case CRDT_MAP_SET:
+			adds, removes := setDiff(ov.Convert(stringsType).Interface().([]string), nv.Convert(stringsType).Interface().([]string))
+			if len(adds) == 0 && len(removes) == 0 {
+				continue
+			}
+			t := rpb.MapField_SET
+			field.Type = &t
+			op.Updates = append(op.Updates, &rpb.MapUpdate{
+				Field: field,
+				SetOp: &rpb.SetOp{Adds: adds, Removes: removes},
+			})
+		case CRDT_MAP_MAP:
+			t := rpb.MapField_MAP
+			field.Type = &t
+			if nv.Kind() == reflect.Ptr {
+				if nv.IsNil() {
+					if !ov.IsNil() {
+						op.Removes = append(op.Removes, field)
+					}
+					continue
+				}
+				if ov.IsNil() {
+					ov = reflect.Zero(nv.Type().Elem())
+				} else {
+					ov = ov.Elem()
+				}
+				nv = nv.Elem()
+			}
+			sub, err := crdtDiff(ov, nv)
+			if err != nil {
+				return nil, err
+			}
+			if sub == nil {
+				continue
+			}
+			op.Updates = append(op.Updates, &rpb.MapUpdate{Field: field, MapOp: sub})
+		}
+	}
+	if len(op.Updates) == 0 && len(op.Removes) == 0 {
+		return nil, nil
+	}
+	return op, nil
+}
+
+// setDiff returns the values which need to be added to and removed from prev to produce next.
+func setDiff(prev, next []string) ([][]byte, [][]byte) {
+	var adds, removes [][]byte
+	seen := make(map[string]bool, len(prev))
+	for _, v := range prev {
+		seen[v] = true
+	}
+	keep := make(map[string]bool, len(next))
+	for _, v := range next {
+		if !seen[v] && !keep[v] {
+			adds = append(adds, []byte(v))
+		}
+		keep[v] = true
+	}
+	for v := range seen {
+		if !keep[v] {
+			removes = append(removes, []byte(v))
+		}
+	}
+	return adds, removes
+}
+
+// structValue dereferences v down to its underlying struct value.
+func structValue(v interface{}) (reflect.Value, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return rv, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.Kind() == reflect.Struct
+}
+
+// FetchInto fetches the CRDT map at key and copies it into the struct pointed to by v.
+//
+// Struct fields are mapped with tags of the form `riak:"name,type"`, where type is
+// one of flag, register, counter, set or map.  Nested structs are stored as nested maps.
+//
+//	type User struct {
+//		Name    string   `riak:"name,register"`
+//		Visits  int64    `riak:"visits,counter"`
+//		Admin   bool     `riak:"admin,flag"`
+//		Tags    []string `riak:"tags,set"`
+//		Address *Address `riak:"address,map"`
+//	}
+func (dt *Crdt) FetchInto(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrCrdtStructPtr
+	}
+	res, err := dt.Fetch()
+	if err != nil {
+		return err
+	}
+	// kept for UpdateFrom, since Fetch resets it
+	dt.context = res.GetContext()
+	return dt.Map.into(rv.Elem())
+}
+
+// UpdateFrom compares the struct values prev and next and sends only the changes to Riak as a MapOp.
+//
+// prev should be the value loaded via FetchInto on the same Crdt (or nil for a new object), so the
+// fetched context is sent along, which Riak needs to remove set elements and map fields.
+// If nothing changed no request is made and a nil response is returned.
+func (dt *Crdt) UpdateFrom(prev, next interface{}) (*rpb.DtUpdateResp, error) {
+	nv, ok := structValue(next)
+	if !ok {
+		return nil, ErrCrdtStructMismatch
+	}
+	ov := reflect.Zero(nv.Type())
+	if prev != nil {
+		if ov, ok = structValue(prev); !ok || ov.Type() != nv.Type() {
+			return nil, ErrCrdtStructMismatch
+		}
+	}
+	op, err := crdtDiff(ov, nv)
+	if err != nil {
+		return nil, err
+	}
+	if op == nil {
+		return nil, nil
+	}
+	opts, ok := dt.opts.(*rpb.DtUpdateReq)
+	if !ok {
+		opts = new(rpb.DtUpdateReq)
+	}
+	opts.Op = &rpb.DtOp{
+		MapOp: op,
+	}
+	return dt.Do(opts).Update()
+}
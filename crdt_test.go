@@ -1,7 +1,11 @@
 package riaken_core
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
 )
 
 func TestCrdtCounter(t *testing.T) {
@@ -307,3 +311,148 @@ func TestCrdtMap(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 }
+
+type crdtTestAddress struct {
+	City string `riak:"city,register"`
+}
+
+type crdtTestUser struct {
+	Name    string           `riak:"name,register"`
+	Visits  int64            `riak:"visits,counter"`
+	Admin   bool             `riak:"admin,flag"`
+	Tags    []string         `riak:"tags,set"`
+	Address *crdtTestAddress `riak:"address,map"`
+	Ignored string
+}
+
+func TestCrdtMapStruct(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	bucket := session.GetBucket("crdt_map_struct").Type("test_maps")
+	user := crdtTestUser{
+		Name:    "bob",
+		Visits:  3,
+		Tags:    []string{"a", "b"},
+		Address: &crdtTestAddress{City: "Portland"},
+	}
+	if _, err := bucket.Crdt("bob").UpdateFrom(nil, &user); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	crdt := bucket.Crdt("bob")
+	var prev crdtTestUser
+	if err := crdt.FetchInto(&prev); err != nil {
+		t.Fatal(err.Error())
+	}
+	if prev.Name != "bob" || prev.Visits != 3 || len(prev.Tags) != 2 {
+		t.Errorf("unexpected fetch: %+v", prev)
+	}
+	if prev.Address == nil || prev.Address.City != "Portland" {
+		t.Error("expected address->city to be Portland")
+	}
+
+	next := prev
+	next.Visits = 5
+	next.Admin = true
+	next.Tags = []string{"b", "c"}
+	next.Address = nil
+	if _, err := crdt.UpdateFrom(prev, next); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var check crdtTestUser
+	if err := bucket.Crdt("bob").FetchInto(&check); err != nil {
+		t.Fatal(err.Error())
+	}
+	if check.Visits != 5 {
+		t.Errorf("expected: %d, got: %d", 5, check.Visits)
+	}
+	if !check.Admin {
+		t.Error("flag should be true")
+	}
+	if len(check.Tags) != 2 {
+		t.Errorf("expected: %d, got: %d", 2, len(check.Tags))
+	}
+	if check.Address != nil {
+		t.Error("expected address to be removed")
+	}
+
+	object := bucket.Object("bob")
+	if _, err := object.Delete(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestCrdtMapStructDiff(t *testing.T) {
+	prev := crdtTestUser{Name: "bob", Visits: 1, Tags: []string{"a"}}
+	next := crdtTestUser{Name: "bob", Visits: 4, Tags: []string{"a", "b"}}
+	op, err := crdtDiff(reflect.ValueOf(prev), reflect.ValueOf(next))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(op.GetUpdates()) != 2 {
+		t.Fatalf("expected: %d, got: %d", 2, len(op.GetUpdates()))
+	}
+	if n := op.GetUpdates()[0].GetCounterOp().GetIncrement(); n != 3 {
+		t.Errorf("expected: %d, got: %d", 3, n)
+	}
+	if op, _ := crdtDiff(reflect.ValueOf(next), reflect.ValueOf(next)); op != nil {
+		t.Error("expected no changes")
+	}
+}
+
+type crdtTestTags []string
+
+type crdtTestTagged struct {
+	Tags crdtTestTags `riak:"tags,set"`
+}
+
+func TestCrdtMapStructContext(t *testing.T) {
+	var sent *rpb.DtUpdateReq
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case Messages["DtFetchReq"]:
+			out, _ := proto.Marshal(&rpb.DtFetchResp{
+				Context: []byte("ctx1"),
+				Type:    rpb.DtFetchResp_MAP.Enum(),
+				Value: &rpb.DtValue{
+					MapValue: []*rpb.MapEntry{{
+						Field:    &rpb.MapField{Name: []byte("tags"), Type: rpb.MapField_SET.Enum()},
+						SetValue: [][]byte{[]byte("a"), []byte("b")},
+					}},
+				},
+			})
+			return Messages["DtFetchResp"], out
+		case Messages["DtUpdateReq"]:
+			sent = &rpb.DtUpdateReq{}
+			proto.Unmarshal(in, sent)
+			out, _ := proto.Marshal(&rpb.DtUpdateResp{})
+			return Messages["DtUpdateResp"], out
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+
+	crdt := session.GetBucket("b1").Type("maps").Crdt("k1")
+	var prev crdtTestTagged
+	if err := crdt.FetchInto(&prev); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(prev.Tags) != 2 {
+		t.Fatalf("unexpected fetch: %+v", prev)
+	}
+	next := crdtTestTagged{Tags: crdtTestTags{"b"}}
+	if _, err := crdt.UpdateFrom(prev, next); err != nil {
+		t.Fatal(err.Error())
+	}
+	if sent == nil || string(sent.GetContext()) != "ctx1" {
+		t.Fatalf("expected the fetched context to be sent, got: %v", sent)
+	}
+	removes := sent.GetOp().GetMapOp().GetUpdates()[0].GetSetOp().GetRemoves()
+	if len(removes) != 1 || string(removes[0]) != "a" {
+		t.Errorf("expected a to be removed, got: %q", removes)
+	}
+}
@@ -0,0 +1,26 @@
+package riaken_core
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer opens the connection of a session to the Riak node at addr.
+//
+// Any net.Conn will do, which allows TLS, Unix sockets, tunnels or net.Pipe in tests.
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// DialTCP is the default Dialer.  It opens a TCP connection with keepalive enabled and
+// otherwise default settings.
+func DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	return ConnConfig{}.Dial(ctx, addr)
+}
+
+// dialer returns the Dialer of the client this session belongs to, or else dials TCP with
+// the connection settings of its node.
+func (s *Session) dialer() Dialer {
+	if c := s.client(); c != nil && c.dialer != nil {
+		return c.dialer
+	}
+	return s.config().Dial
+}
@@ -0,0 +1,64 @@
+package riaken_core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestClientDialer(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	client := NewClient([]string{"node1", "node2"}, 1)
+	client.SetDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		c1, c2 := net.Pipe()
+		go fakeServe(c2, "2.1.4", fakeEcho)
+		return c1, nil
+	})
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		session := client.Session()
+		if !session.Ping() {
+			t.Errorf("no ping response from %s", session.addr)
+		}
+		session.Release()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 2 || dialed[0] == dialed[1] {
+		t.Errorf("expected both nodes to be dialed once, got: %v", dialed)
+	}
+}
+
+func TestClientDialerError(t *testing.T) {
+	failed := errors.New("no route")
+	client := NewClient([]string{"node1"}, 1)
+	client.SetDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return nil, failed
+	})
+	if err := client.Dial(); err == nil {
+		client.Close()
+		t.Error("expected dial to fail")
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	conn, err := DialTCP(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Errorf("expected a TCP connection, got: %T", conn)
+	}
+}
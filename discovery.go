@@ -0,0 +1,33 @@
+package riaken_core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Discovery returns the current list of Riak node addresses.  See Client.Discover.
+type Discovery func() ([]string, error)
+
+// StaticDiscovery always returns addrs.
+func StaticDiscovery(addrs ...string) Discovery {
+	return func() ([]string, error) {
+		return addrs, nil
+	}
+}
+
+// SRVDiscovery resolves the Riak nodes from the DNS SRV records for service, proto, and name,
+// e.g. SRVDiscovery("riak-pb", "tcp", "riak.example.com") looks up _riak-pb._tcp.riak.example.com.
+func SRVDiscovery(service, proto, name string) Discovery {
+	return func() ([]string, error) {
+		_, records, err := net.LookupSRV(service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, len(records))
+		for i, r := range records {
+			addrs[i] = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), fmt.Sprintf("%d", r.Port))
+		}
+		return addrs, nil
+	}
+}
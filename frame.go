@@ -0,0 +1,76 @@
+package riaken_core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MaxFrameSize is the default limit on the size of a response frame from Riak.
+const MaxFrameSize int = 64 << 20
+
+// pooledFrameSize is the largest frame buffer kept for reuse, so one huge response does
+// not pin its memory in the pool.
+const pooledFrameSize int = 1 << 20
+
+// FrameSizeError is returned when Riak announces a response larger than the frame size limit.
+// The connection is closed since the rest of the stream can no longer be trusted.
+type FrameSizeError struct {
+	Size uint32 // announced size of the frame
+	Max  int    // limit in force
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("response frame of %d bytes exceeds the limit of %d", e.Size, e.Max)
+}
+
+// framePool holds response buffers for reuse.
+var framePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// getFrame returns a buffer of length size, from the pool if it has one big enough.
+func getFrame(size int) []byte {
+	if size > pooledFrameSize {
+		return make([]byte, size)
+	}
+	b := *framePool.Get().(*[]byte)
+	if cap(b) < size {
+		b = make([]byte, size)
+	}
+	return b[:size]
+}
+
+// putFrame hands b back to the pool once nothing refers to it anymore.
+func putFrame(b []byte) {
+	if b == nil || cap(b) > pooledFrameSize {
+		return
+	}
+	b = b[:0]
+	framePool.Put(&b)
+}
+
+// frameSize returns the size of the response frame resp on the wire, including its length prefix.
+func frameSize(resp []byte) int {
+	if resp == nil {
+		return 0
+	}
+	return len(resp) + 4
+}
+
+// maxFrame returns the frame size limit of the client this session belongs to.
+func (s *Session) maxFrame() int {
+	if c := s.client(); c != nil && c.maxFrame > 0 {
+		return c.maxFrame
+	}
+	return MaxFrameSize
+}
+
+// releaseWrite drops the request buffer once a large request has grown it past pooledFrameSize.
+func (s *Session) releaseWrite() {
+	if cap(s.wbuf.Bytes()) > pooledFrameSize {
+		s.wbuf.SetBuf(nil)
+	}
+}
@@ -0,0 +1,74 @@
+package riaken_core
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestSessionMaxFrameSize(t *testing.T) {
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		if code == Messages["GetReq"] {
+			out, _ := proto.Marshal(&rpb.RpbGetResp{
+				Content: []*rpb.RpbContent{{Value: make([]byte, 1024)}},
+			})
+			return Messages["GetResp"], out
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	client.SetMaxFrameSize(512)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	if !session.Ping() {
+		t.Error("no ping response")
+	}
+	_, err := session.GetBucket("b1").Object("o1").Fetch()
+	if e, ok := err.(*FrameSizeError); !ok || e.Max != 512 || e.Size < 1024 {
+		t.Errorf("expected a frame size error, got: %v", err)
+	}
+	if session.Available() {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestFramePool(t *testing.T) {
+	b := getFrame(100)
+	if len(b) != 100 {
+		t.Fatalf("expected: %d bytes, got: %d", 100, len(b))
+	}
+	putFrame(b)
+	if b := getFrame(pooledFrameSize + 1); len(b) != pooledFrameSize+1 {
+		t.Errorf("expected: %d bytes, got: %d", pooledFrameSize+1, len(b))
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		putFrame(getFrame(2048))
+	})
+	// only the pointer handed back to the pool is allocated
+	if allocs > 1 {
+		t.Errorf("expected at most 1 allocation, got: %v", allocs)
+	}
+}
+
+func TestRpbFrame(t *testing.T) {
+	body, _ := proto.Marshal(&rpb.RpbGetReq{Bucket: []byte("b1"), Key: []byte("o1")})
+	buf := rpbFrame(nil, Messages["PingReq"], nil)
+	buf = rpbFrame(buf, Messages["GetReq"], body)
+	expected := append([]byte{0, 0, 0, 1, Messages["PingReq"], 0, 0, 0, byte(len(body) + 1), Messages["GetReq"]}, body...)
+	if string(buf) != string(expected) {
+		t.Errorf("expected: %v, got: %v", expected, buf)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = rpbFrame(buf[:0], Messages["GetReq"], body)
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations, got: %v", allocs)
+	}
+}
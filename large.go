@@ -0,0 +1,290 @@
+package riaken_core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LargeChunkSize is the size of each chunk stored by PutLarge.
+const LargeChunkSize int = 1 << 20
+
+// LargeFetchers is the number of chunks GetLarge fetches in parallel.
+const LargeFetchers int = 4
+
+var ErrObjectNotFound error = errors.New("object not found")
+var ErrNotLarge error = errors.New("object is not a large object manifest")
+var ErrChecksum error = errors.New("chunk checksum mismatch")
+var ErrSiblings error = errors.New("object has siblings")
+
+// Manifest lists the chunks of a large object, in order.
+type Manifest struct {
+	Size      int64   `json:"size"`
+	ChunkSize int     `json:"chunk_size"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// Chunk is a single piece of a large object.
+type Chunk struct {
+	Key      string `json:"key"`
+	Size     int    `json:"size"`
+	Checksum string `json:"sha256"` // hex encoded SHA-256 of the chunk data
+}
+
+// PutLarge reads r until EOF and stores it as LargeChunkSize chunks, followed by a manifest at
+// key listing them.  Chunks of a large object previously stored at key are deleted afterwards.
+//
+// ErrNotLarge is returned if an ordinary object is stored at key, and ErrSiblings if the
+// manifest at key has siblings.  The new manifest is written with the vector clock of the old
+// one.  On failure the chunks already stored are deleted again.
+func (b *Bucket) PutLarge(key string, r io.Reader) (*Manifest, error) {
+	old, object, err := b.manifest(key)
+	if err != nil && err != ErrObjectNotFound {
+		return nil, err
+	}
+	m, err := b.putLarge(object, r)
+	if err != nil {
+		if m != nil {
+			b.deleteChunks(m)
+		}
+		return nil, err
+	}
+	if old != nil {
+		b.deleteChunks(old)
+	}
+	return m, nil
+}
+
+// putLarge stores the chunks and the manifest at object for PutLarge, returning the chunks
+// stored so far on failure.
+func (b *Bucket) putLarge(object *Object, r io.Reader) (*Manifest, error) {
+	key := object.key
+	upload := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, upload); err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		ChunkSize: LargeChunkSize,
+	}
+	buf := make([]byte, LargeChunkSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return m, err
+		}
+		sum := sha256.Sum256(buf[:n])
+		chunk := Chunk{
+			Key:      fmt.Sprintf("%s/chunk/%s/%d", key, hex.EncodeToString(upload), i),
+			Size:     n,
+			Checksum: hex.EncodeToString(sum[:]),
+		}
+		if _, err := b.Object(chunk.Key).Store(buf[:n]); err != nil {
+			return m, err
+		}
+		m.Chunks = append(m.Chunks, chunk)
+		m.Size += int64(n)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	object.ContentType([]byte("application/json"))
+	if _, err := object.Store(data); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// GetLarge returns a reader over the large object stored at key by PutLarge.
+//
+// Chunks are fetched LargeFetchers at a time ahead of the reader and verified against the
+// manifest, in which case ErrChecksum is returned by Read.  The bucket session is used by
+// the reader in the background, along with idle sessions taken from the client if any, so it
+// must not be used by the caller until the reader is closed.
+func (b *Bucket) GetLarge(key string) (io.ReadCloser, error) {
+	m, _, err := b.manifest(key)
+	if err != nil {
+		return nil, err
+	}
+	r := &largeReader{
+		chunks: make([]chan largeChunk, len(m.Chunks)),
+		window: make(chan bool, LargeFetchers*2),
+		done:   make(chan bool),
+	}
+	for i := range r.chunks {
+		r.chunks[i] = make(chan largeChunk, 1)
+	}
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range m.Chunks {
+			select {
+			case jobs <- i:
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	sessions := []*Session{b.session}
+	if c := b.session.client(); c != nil {
+		for i := 1; i < LargeFetchers && i < len(m.Chunks); i++ {
+			s := c.session("", false)
+			if s == nil {
+				break
+			}
+			sessions = append(sessions, s)
+		}
+	}
+	for i, s := range sessions {
+		r.wg.Add(1)
+		go r.fetch(&Bucket{session: s, name: b.name, btype: b.btype, cipher: b.cipher}, m, jobs, i > 0)
+	}
+	return r, nil
+}
+
+// DeleteLarge removes the large object stored at key by PutLarge, chunks first.
+func (b *Bucket) DeleteLarge(key string) error {
+	m, object, err := b.manifest(key)
+	if err != nil {
+		return err
+	}
+	if err := b.deleteChunks(m); err != nil {
+		return err
+	}
+	_, err = object.Delete()
+	return err
+}
+
+// manifest fetches the manifest stored at key, along with its object holding the vector clock
+// read.  ErrSiblings is returned if there is more than one manifest.
+func (b *Bucket) manifest(key string) (*Manifest, *Object, error) {
+	object := b.Object(key)
+	res, err := object.Fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.GetContent()) == 0 || object.Deleted() {
+		return nil, object, ErrObjectNotFound
+	}
+	if len(res.GetContent()) > 1 {
+		return nil, object, ErrSiblings
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(res.GetContent()[0].GetValue(), m); err != nil || m.ChunkSize == 0 {
+		return nil, object, ErrNotLarge
+	}
+	return m, object, nil
+}
+
+// deleteChunks removes every chunk listed in m.
+func (b *Bucket) deleteChunks(m *Manifest) error {
+	for _, chunk := range m.Chunks {
+		if _, err := b.Object(chunk.Key).Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type largeChunk struct {
+	data []byte
+	err  error
+}
+
+// largeReader reads the chunks of a large object in order while they are fetched in parallel.
+type largeReader struct {
+	chunks []chan largeChunk // result of each chunk
+	window chan bool         // limits how far ahead chunks are fetched
+	next   int               // next chunk to read
+	buf    []byte            // rest of the current chunk
+	err    error             // sticky read error
+	done   chan bool         // closed by Close
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// fetch fetches the chunks given by jobs from bucket b, releasing its session when done if release is set.
+func (r *largeReader) fetch(b *Bucket, m *Manifest, jobs chan int, release bool) {
+	defer r.wg.Done()
+	if release {
+		defer b.session.Release()
+	}
+	for {
+		// take a slot before a job so the next chunk to read always gets one
+		select {
+		case r.window <- true:
+		case <-r.done:
+			return
+		}
+		i, ok := <-jobs
+		if !ok {
+			return
+		}
+		var c largeChunk
+		res, err := b.Object(m.Chunks[i].Key).Fetch()
+		switch {
+		case err != nil:
+			c.err = err
+		case len(res.GetContent()) == 0:
+			c.err = ErrObjectNotFound
+		default:
+			c.data = res.GetContent()[0].GetValue()
+			sum := sha256.Sum256(c.data)
+			if len(c.data) != m.Chunks[i].Size || hex.EncodeToString(sum[:]) != m.Chunks[i].Checksum {
+				c.err = ErrChecksum
+			}
+		}
+		r.chunks[i] <- c
+	}
+}
+
+func (r *largeReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next == len(r.chunks) {
+			return 0, io.EOF
+		}
+		select {
+		case c := <-r.chunks[r.next]:
+			<-r.window
+			if c.err != nil {
+				r.err = c.err
+				continue
+			}
+			r.buf = c.data
+			r.next++
+		case <-r.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops fetching and waits until the sessions used by the reader are free again.
+func (r *largeReader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+	return nil
+}
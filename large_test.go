@@ -0,0 +1,229 @@
+package riaken_core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestBucketLarge(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	addr, stop := fakeServer(t, store.handle)
+	defer stop()
+	client := NewClient([]string{addr}, 4)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+	bucket := session.GetBucket("files")
+
+	data := make([]byte, LargeChunkSize*5/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	m, err := bucket.PutLarge("f1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(m.Chunks) != 3 || m.Size != int64(len(data)) || m.Chunks[2].Size != LargeChunkSize/2 {
+		t.Errorf("unexpected manifest: %+v", m)
+	}
+
+	r, err := bucket.GetLarge("f1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	out, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(out, data) {
+		t.Error("large object did not round trip")
+	}
+
+	// overwriting removes the old chunks
+	m2, err := bucket.PutLarge("f1", strings.NewReader("small"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	store.mu.Lock()
+	if len(store.objects) != 2 {
+		t.Errorf("expected: 2 objects, got: %d", len(store.objects))
+	}
+	// corrupt the only chunk
+	store.objects[m2.Chunks[0].Key].Value = []byte("smell")
+	store.mu.Unlock()
+	r, err = bucket.GetLarge("f1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := io.ReadAll(r); err != ErrChecksum {
+		t.Errorf("expected: %v, got: %v", ErrChecksum, err)
+	}
+	r.Close()
+
+	if err := bucket.DeleteLarge("f1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	store.mu.Lock()
+	if len(store.objects) != 0 {
+		t.Errorf("expected: no objects, got: %d", len(store.objects))
+	}
+	store.mu.Unlock()
+	if _, err := bucket.GetLarge("f1"); err != ErrObjectNotFound {
+		t.Errorf("expected: %v, got: %v", ErrObjectNotFound, err)
+	}
+}
+
+func TestBucketLargeClose(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	addr, stop := fakeServer(t, store.handle)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+	bucket := session.GetBucket("files")
+
+	if _, err := bucket.PutLarge("f1", bytes.NewReader(make([]byte, LargeChunkSize*10))); err != nil {
+		t.Fatal(err.Error())
+	}
+	r, err := bucket.GetLarge("f1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err.Error())
+	}
+	r.Close()
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Error("expected an error reading a closed reader")
+	}
+	// the extra session was released
+	if s := client.Session(); s == nil {
+		t.Error("expected a free session after Close")
+	} else {
+		s.Release()
+	}
+}
+
+// failReader returns n bytes of data and then fails.
+type failReader struct {
+	n int
+}
+
+func (r *failReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestBucketLargeFailure(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	addr, stop := fakeServer(t, store.handle)
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+	bucket := session.GetBucket("files")
+
+	// the chunks stored before the reader fails are deleted again
+	if _, err := bucket.PutLarge("f1", &failReader{n: LargeChunkSize * 2}); err != io.ErrClosedPipe {
+		t.Errorf("expected: %v, got: %v", io.ErrClosedPipe, err)
+	}
+	store.mu.Lock()
+	if len(store.objects) != 0 {
+		t.Errorf("expected: no objects, got: %d", len(store.objects))
+	}
+	store.mu.Unlock()
+
+	// an ordinary object is not overwritten
+	if _, err := bucket.Object("o1").Store([]byte("plain")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bucket.PutLarge("o1", strings.NewReader("large")); err != ErrNotLarge {
+		t.Errorf("expected: %v, got: %v", ErrNotLarge, err)
+	}
+	if v := store.get("o1").GetValue(); string(v) != "plain" {
+		t.Errorf("expected: plain, got: %q", v)
+	}
+	store.mu.Lock()
+	if len(store.objects) != 1 {
+		t.Errorf("expected: 1 object, got: %d", len(store.objects))
+	}
+	store.mu.Unlock()
+}
+
+func TestBucketLargeVClock(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	var mu sync.Mutex
+	vclocks := make(map[string][]byte)
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case Messages["PutReq"]:
+			req := &rpb.RpbPutReq{}
+			proto.Unmarshal(in, req)
+			mu.Lock()
+			vclocks[string(req.GetKey())] = req.GetVclock()
+			mu.Unlock()
+		case Messages["GetReq"]:
+			req := &rpb.RpbGetReq{}
+			proto.Unmarshal(in, req)
+			if string(req.GetKey()) == "sib" {
+				c := &rpb.RpbContent{Value: []byte(`{"chunk_size":1}`)}
+				out, _ := proto.Marshal(&rpb.RpbGetResp{Content: []*rpb.RpbContent{c, c}, Vclock: []byte("vclock")})
+				return Messages["GetResp"], out
+			}
+		}
+		return store.handle(code, in)
+	})
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+	bucket := session.GetBucket("files")
+
+	if _, err := bucket.PutLarge("f1", strings.NewReader("one")); err != nil {
+		t.Fatal(err.Error())
+	}
+	// overwriting writes the manifest over the old one instead of next to it
+	if _, err := bucket.PutLarge("f1", strings.NewReader("two")); err != nil {
+		t.Fatal(err.Error())
+	}
+	mu.Lock()
+	if string(vclocks["f1"]) != "vclock" {
+		t.Errorf("expected: vclock, got: %q", vclocks["f1"])
+	}
+	mu.Unlock()
+
+	if _, err := bucket.GetLarge("sib"); err != ErrSiblings {
+		t.Errorf("expected: %v, got: %v", ErrSiblings, err)
+	}
+	if _, err := bucket.PutLarge("sib", strings.NewReader("three")); err != ErrSiblings {
+		t.Errorf("expected: %v, got: %v", ErrSiblings, err)
+	}
+}
@@ -0,0 +1,113 @@
+package riaken_core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	LOG_DEBUG LogLevel = 0
+	LOG_INFO  LogLevel = 1
+	LOG_WARN  LogLevel = 2
+	LOG_ERROR LogLevel = 3
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LOG_DEBUG:
+		return "debug"
+	case LOG_INFO:
+		return "info"
+	case LOG_WARN:
+		return "warn"
+	case LOG_ERROR:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Field is a structured key/value attached to a log entry, such as the node address or opcode.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives diagnostic output from the client and its sessions.
+//
+// Entries carry fields such as "node", "opcode", "latency" and "error" where they apply.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+var (
+	defaultLogger Logger = StdLogger(log.New(os.Stderr, "", log.LstdFlags), LOG_WARN)
+	debugLogger   Logger = StdLogger(log.New(os.Stderr, "", log.LstdFlags), LOG_DEBUG)
+)
+
+type stdLogger struct {
+	l   *log.Logger
+	min LogLevel
+}
+
+// StdLogger adapts a standard library logger, dropping entries below min.
+//
+// Entries are written as "level: msg key=value ...".
+func StdLogger(l *log.Logger, min LogLevel) Logger {
+	return &stdLogger{
+		l:   l,
+		min: min,
+	}
+}
+
+func (s *stdLogger) Log(level LogLevel, msg string, fields ...Field) {
+	if level < s.min {
+		return
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s: %s", level, msg)
+	for _, f := range fields {
+		fmt.Fprintf(buf, " %s=%v", f.Key, f.Value)
+	}
+	s.l.Print(buf.String())
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// SlogLogger adapts a log/slog logger.  Fields become slog attributes.
+func SlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{
+		l: l,
+	}
+}
+
+func (s *slogLogger) Log(level LogLevel, msg string, fields ...Field) {
+	var lvl slog.Level
+	switch level {
+	case LOG_DEBUG:
+		lvl = slog.LevelDebug
+	case LOG_INFO:
+		lvl = slog.LevelInfo
+	case LOG_WARN:
+		lvl = slog.LevelWarn
+	default:
+		lvl = slog.LevelError
+	}
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, lvl) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, lvl, msg, attrs...)
+}
@@ -0,0 +1,82 @@
+package riaken_core
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogEntry struct {
+	level  LogLevel
+	msg    string
+	fields []Field
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, testLogEntry{level, msg, fields})
+}
+
+func (l *testLogger) find(msg string) *testLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return &e
+		}
+	}
+	return nil
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := StdLogger(log.New(buf, "", 0), LOG_INFO)
+	logger.Log(LOG_DEBUG, "hidden")
+	logger.Log(LOG_WARN, "dial failed", Field{"node", "127.0.0.1:10017"}, Field{"error", "refused"})
+	if out := buf.String(); out != "warn: dial failed node=127.0.0.1:10017 error=refused\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := SlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logger.Log(LOG_DEBUG, "hidden")
+	logger.Log(LOG_ERROR, "session panicked", Field{"node", "127.0.0.1:10017"})
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Error("expected debug entry to be dropped")
+	}
+	if !strings.Contains(out, "level=ERROR") || !strings.Contains(out, `msg="session panicked"`) || !strings.Contains(out, "node=127.0.0.1:10017") {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestClientLogger(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	stop()
+	logger := &testLogger{}
+	client := NewClient([]string{addr}, 1)
+	client.SetLogger(logger)
+	if err := client.Dial(); err != ErrAllNodesDown {
+		t.Errorf("expected: %v, got: %v", ErrAllNodesDown, err)
+	}
+	defer client.Close()
+
+	e := logger.find("dial failed")
+	if e == nil {
+		t.Fatal("expected dial failure to be logged")
+	}
+	if e.level != LOG_INFO || e.fields[0].Key != "node" || e.fields[0].Value != addr {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
@@ -0,0 +1,121 @@
+package riaken_core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// Link is a Riak link to another object.
+type Link struct {
+	Bucket string
+	Key    string
+	Tag    string
+}
+
+// Metadata describes an object without its value.
+type Metadata struct {
+	ContentType  string
+	Charset      string
+	Encoding     string
+	VTag         string
+	LastModified time.Time
+	Meta         map[string]string   // user metadata
+	Indexes      map[string][]string // secondary index entries
+	Links        []Link
+	Deleted      bool
+	Siblings     int // number of siblings, 1 if there are none
+}
+
+// Head fetches the metadata of this object without transferring its value.
+//
+// Returns nil if the object does not exist.  If the object has siblings the metadata
+// describes the first one.  The vector clock is kept as with Fetch.
+func (o *Object) Head() (*Metadata, error) {
+	opts, err := o.getOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts.Head = proto.Bool(true)
+	res, err := o.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	content := res.GetContent()
+	if len(content) == 0 {
+		return nil, nil
+	}
+	c := content[0]
+	return &Metadata{
+		ContentType:  string(c.GetContentType()),
+		Charset:      string(c.GetCharset()),
+		Encoding:     string(c.GetContentEncoding()),
+		VTag:         string(c.GetVtag()),
+		LastModified: lastModified(c),
+		Meta:         userMeta(c),
+		Indexes:      indexes(c),
+		Links:        links(c),
+		Deleted:      c.GetDeleted(),
+		Siblings:     len(content),
+	}, nil
+}
+
+// getOpts returns the RpbGetReq set with Do, setting a new one if there is none.
+func (o *Object) getOpts() (*rpb.RpbGetReq, error) {
+	if o.opts == nil {
+		o.opts = new(rpb.RpbGetReq)
+	}
+	opts, ok := o.opts.(*rpb.RpbGetReq)
+	if !ok {
+		o.reset()
+		return nil, errors.New("Called Do() with wrong opts. Should be RpbGetReq")
+	}
+	return opts, nil
+}
+
+// lastModified builds the modification time of c from last_mod and last_mod_usecs.
+func lastModified(c *rpb.RpbContent) time.Time {
+	if c.LastMod == nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(c.GetLastMod()), int64(c.GetLastModUsecs())*int64(time.Microsecond))
+}
+
+// userMeta returns the user metadata of c, or nil if there is none.
+func userMeta(c *rpb.RpbContent) map[string]string {
+	if len(c.GetUsermeta()) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(c.GetUsermeta()))
+	for _, p := range c.GetUsermeta() {
+		out[string(p.GetKey())] = string(p.GetValue())
+	}
+	return out
+}
+
+// indexes returns the secondary index entries of c, or nil if there are none.
+func indexes(c *rpb.RpbContent) map[string][]string {
+	if len(c.GetIndexes()) == 0 {
+		return nil
+	}
+	out := make(map[string][]string)
+	for _, p := range c.GetIndexes() {
+		out[string(p.GetKey())] = append(out[string(p.GetKey())], string(p.GetValue()))
+	}
+	return out
+}
+
+// links returns the links of c.
+func links(c *rpb.RpbContent) []Link {
+	var out []Link
+	for _, l := range c.GetLinks() {
+		out = append(out, Link{
+			Bucket: string(l.GetBucket()),
+			Key:    string(l.GetKey()),
+			Tag:    string(l.GetTag()),
+		})
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package riaken_core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestObjectHead(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	object := session.GetBucket("b1").Object("head1")
+	if meta, err := object.Head(); err != nil {
+		t.Error(err.Error())
+	} else if meta != nil {
+		t.Errorf("expected no metadata for a missing object, got: %+v", meta)
+	}
+	object.ContentType([]byte("text/plain"))
+	if _, err := object.Store([]byte("head1-data")); err != nil {
+		t.Error(err.Error())
+	}
+	meta, err := object.Head()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if meta == nil || meta.ContentType != "text/plain" || meta.Siblings != 1 || meta.LastModified.IsZero() {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if _, err := object.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestObjectHeadFake(t *testing.T) {
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		req := &rpb.RpbGetReq{}
+		proto.Unmarshal(in, req)
+		if !req.GetHead() {
+			t.Error("expected a head request")
+		}
+		out, _ := proto.Marshal(&rpb.RpbGetResp{
+			Content: []*rpb.RpbContent{
+				{
+					Value:        []byte{},
+					ContentType:  []byte("application/json"),
+					Charset:      []byte("utf-8"),
+					Vtag:         []byte("tag1"),
+					LastMod:      proto.Uint32(1400000000),
+					LastModUsecs: proto.Uint32(500),
+					Usermeta:     []*rpb.RpbPair{{Key: []byte("owner"), Value: []byte("me")}},
+					Indexes: []*rpb.RpbPair{
+						{Key: []byte("color_bin"), Value: []byte("red")},
+						{Key: []byte("color_bin"), Value: []byte("blue")},
+					},
+					Links: []*rpb.RpbLink{{Bucket: []byte("b2"), Key: []byte("k2"), Tag: []byte("friend")}},
+				},
+				{Value: []byte{}},
+			},
+			Vclock: []byte("vclock"),
+		})
+		return Messages["GetResp"], out
+	})
+	defer stop()
+
+	object := session.GetBucket("b1").Object("o1")
+	meta, err := object.Head()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if meta.ContentType != "application/json" || meta.Charset != "utf-8" || meta.VTag != "tag1" || meta.Siblings != 2 || meta.Deleted {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if !meta.LastModified.Equal(time.Unix(1400000000, 500000)) {
+		t.Errorf("unexpected last modified: %v", meta.LastModified)
+	}
+	if meta.Meta["owner"] != "me" || len(meta.Indexes["color_bin"]) != 2 || meta.Links[0] != (Link{"b2", "k2", "friend"}) {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if string(object.VClock()) != "vclock" {
+		t.Errorf("expected: vclock, got: %s", object.VClock())
+	}
+}
@@ -0,0 +1,118 @@
+package riaken_core
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// MultiOpts configures FetchMany and StoreMany.
+type MultiOpts struct {
+	Type        string // bucket type, Riak uses 'default' if not set
+	Concurrency int    // maximum sessions used at once, defaults to the size of the session pool
+	Cipher      Cipher // encrypts stored values and decrypts fetched ones, see Bucket.Cipher
+}
+
+// MultiResult is the outcome for a single key of FetchMany or StoreMany.
+type MultiResult struct {
+	Key   string
+	Fetch *rpb.RpbGetResp // set by FetchMany
+	Store *rpb.RpbPutResp // set by StoreMany
+	Err   error
+}
+
+// FetchMany fetches keys from bucket in parallel across the pooled sessions.
+//
+// Results are returned in the same order as keys, each with its own error.
+func (c *Client) FetchMany(bucket string, keys []string, opts *MultiOpts) []*MultiResult {
+	results := make([]*MultiResult, len(keys))
+	for i, key := range keys {
+		results[i] = &MultiResult{Key: key}
+	}
+	c.multi(bucket, results, opts, func(b *Bucket, res *MultiResult) error {
+		var err error
+		res.Fetch, err = b.Object(res.Key).Fetch()
+		return err
+	})
+	return results
+}
+
+// StoreMany stores values in bucket in parallel across the pooled sessions.
+//
+// Results are returned sorted by key, each with its own error.
+func (c *Client) StoreMany(bucket string, values map[string][]byte, opts *MultiOpts) []*MultiResult {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	results := make([]*MultiResult, len(keys))
+	for i, key := range keys {
+		results[i] = &MultiResult{Key: key}
+	}
+	c.multi(bucket, results, opts, func(b *Bucket, res *MultiResult) error {
+		var err error
+		res.Store, err = b.Object(res.Key).Store(values[res.Key])
+		return err
+	})
+	return results
+}
+
+// multi fans fn out over a set of workers, each holding its own session.
+//
+// A worker whose session fails releases it and grabs another one for the next key.
+func (c *Client) multi(bucket string, results []*MultiResult, opts *MultiOpts, fn func(*Bucket, *MultiResult) error) {
+	if opts == nil {
+		opts = &MultiOpts{}
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = c.size()
+	}
+	if workers > len(results) {
+		workers = len(results)
+	}
+
+	jobs := make(chan *MultiResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s *Session
+			defer func() {
+				if s != nil {
+					s.Release()
+				}
+			}()
+			for res := range jobs {
+				if s == nil {
+					if s = c.Session(); s == nil {
+						res.Err = ErrAllNodesDown
+						if c.closed() {
+							res.Err = ErrClientClosed
+						}
+						continue
+					}
+				}
+				b := s.GetBucket(bucket)
+				if opts.Type != "" {
+					b.Type(opts.Type)
+				}
+				if opts.Cipher != nil {
+					b.Cipher(opts.Cipher)
+				}
+				if res.Err = fn(b, res); res.Err != nil && !s.Available() {
+					s.Release()
+					s = nil
+				}
+			}
+		}()
+	}
+	for _, res := range results {
+		jobs <- res
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,125 @@
+package riaken_core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestClientStoreFetchMany(t *testing.T) {
+	client := dial()
+	defer client.Close()
+
+	values := make(map[string][]byte)
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("m%d", i)
+		keys = append(keys, key)
+		values[key] = []byte(key + "-data")
+	}
+	for _, res := range client.StoreMany("b1-many", values, &MultiOpts{Concurrency: 10}) {
+		if res.Err != nil {
+			t.Errorf("%s: %s", res.Key, res.Err.Error())
+		}
+	}
+
+	results := client.FetchMany("b1-many", keys, nil)
+	if len(results) != len(keys) {
+		t.Fatalf("expected: %d, got: %d", len(keys), len(results))
+	}
+	for i, res := range results {
+		if res.Key != keys[i] {
+			t.Errorf("expected: %s, got: %s", keys[i], res.Key)
+		}
+		if res.Err != nil {
+			t.Errorf("%s: %s", res.Key, res.Err.Error())
+		} else if len(res.Fetch.GetContent()) > 0 {
+			if string(res.Fetch.GetContent()[0].GetValue()) != res.Key+"-data" {
+				t.Errorf("got %s, expected %s-data", string(res.Fetch.GetContent()[0].GetValue()), res.Key)
+			}
+		}
+	}
+
+	session := client.Session()
+	defer session.Release()
+	bucket := session.GetBucket("b1-many")
+	for _, key := range keys {
+		if _, err := bucket.Object(key).Delete(); err != nil {
+			t.Error(err.Error())
+		}
+	}
+}
+
+func TestClientFetchManyFake(t *testing.T) {
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		if code != Messages["GetReq"] {
+			return fakeEcho(code, in)
+		}
+		req := &rpb.RpbGetReq{}
+		proto.Unmarshal(in, req)
+		out, _ := proto.Marshal(&rpb.RpbGetResp{
+			Content: []*rpb.RpbContent{{Value: req.GetKey()}},
+		})
+		return Messages["GetResp"], out
+	})
+	defer stop()
+	client := NewClient([]string{addr}, 4)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	var keys []string
+	for i := 0; i < 500; i++ {
+		keys = append(keys, fmt.Sprintf("k%d", i))
+	}
+	for i, res := range client.FetchMany("b1", keys, &MultiOpts{Concurrency: 3}) {
+		if res.Err != nil {
+			t.Fatal(res.Err.Error())
+		}
+		if string(res.Fetch.GetContent()[0].GetValue()) != keys[i] {
+			t.Errorf("expected: %s, got: %s", keys[i], res.Fetch.GetContent()[0].GetValue())
+		}
+	}
+}
+
+func TestClientManyCipher(t *testing.T) {
+	store := &fakeStore{objects: make(map[string]*rpb.RpbContent)}
+	addr, stop := fakeServer(t, store.handle)
+	defer stop()
+	client := NewClient([]string{addr}, 2)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	cipher, err := NewAESGCMCipher("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts := &MultiOpts{Cipher: cipher}
+	for _, res := range client.StoreMany("pii", map[string][]byte{"o1": []byte("secret1"), "o2": []byte("secret2")}, opts) {
+		if res.Err != nil {
+			t.Fatal(res.Err.Error())
+		}
+		if id, _ := keyID(store.get(res.Key)); id != "k1" {
+			t.Errorf("%s was not encrypted", res.Key)
+		}
+	}
+	for _, res := range client.FetchMany("pii", []string{"o1", "o2"}, opts) {
+		if res.Err != nil {
+			t.Fatal(res.Err.Error())
+		}
+		if v := string(res.Fetch.GetContent()[0].GetValue()); v != "secret"+res.Key[1:] {
+			t.Errorf("expected the value of %s decrypted, got: %q", res.Key, v)
+		}
+	}
+	for _, res := range client.FetchMany("pii", []string{"o1"}, nil) {
+		if res.Err != ErrNoCipher {
+			t.Errorf("expected: %v, got: %v", ErrNoCipher, res.Err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package riaken_core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyDecay is the weight given to the newest sample in a node's latency moving average.
+const LatencyDecay float64 = 0.2
+
+// BreakerState is the circuit breaker state of a node.
+type BreakerState int
+
+const (
+	BREAKER_CLOSED    BreakerState = 0 // node is healthy and in rotation
+	BREAKER_OPEN      BreakerState = 1 // node is out of rotation until the cooldown passes
+	BREAKER_HALF_OPEN BreakerState = 2 // node is being probed to see if it has recovered
+)
+
+func (b BreakerState) String() string {
+	switch b {
+	case BREAKER_CLOSED:
+		return "closed"
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker configures when a node is pulled out of rotation.
+type CircuitBreaker struct {
+	Threshold int           // consecutive failures before the node is pulled, 0 disables the breaker
+	Cooldown  time.Duration // time out of rotation before the node is probed again
+}
+
+// DefaultCircuitBreaker is used by clients unless changed with SetCircuitBreaker.
+var DefaultCircuitBreaker = CircuitBreaker{
+	Threshold: 5,
+	Cooldown:  time.Second * 30,
+}
+
+// NodeStatus is a snapshot of the health of a node.
+type NodeStatus struct {
+	Addr        string        // node address
+	State       BreakerState  // circuit breaker state
+	Failures    int           // consecutive failures
+	Latency     time.Duration // moving average of request latency
+	LastError   error         // most recent failure, if any
+	LastErrorAt time.Time     // time of the most recent failure
+	Sessions    int           // number of sessions connected to this node
+	Outstanding int           // number of sessions currently checked out
+	ClientIds   [][]byte      // client ID of each session, nil where none was set
+	Version     ServerVersion // Riak version, read when a session dials
+}
+
+// Node tracks the health of a single Riak node, shared by all sessions connected to it.
+type Node struct {
+	addr        string
+	client      *Client       // client settings such as the circuit breaker
+	sessions    int           // number of sessions for this node
+	pool        chan *Session // idle sessions for this node
+	all         []*Session    // every session for this node, idle or not
+	closed      int32         // set once the client is shut down, accessed atomically
+	removed     chan bool     // closed once the node is removed from the cluster
+	drainDone   chan bool     // closed once the drain of a removed node stops
+	outstanding int64         // sessions currently checked out, accessed atomically
+	mu          sync.Mutex
+	state       BreakerState
+	failures    int
+	latency     time.Duration
+	lastErr     error
+	lastErrAt   time.Time
+	openedAt    time.Time
+	clientIds   map[*Session][]byte // client ID set on each session
+	version     ServerVersion       // Riak version reported by the node
+	drained     map[*Session]bool   // sessions closed by a drain or shutdown
+}
+
+func newNode(addr string, sessions int, client *Client) *Node {
+	n := &Node{
+		addr:      addr,
+		client:    client,
+		sessions:  sessions,
+		pool:      make(chan *Session, sessions),
+		removed:   make(chan bool),
+		drainDone: make(chan bool),
+		drained:   make(map[*Session]bool),
+	}
+	for i := 0; i < sessions; i++ {
+		s := NewSession(n.pool, addr)
+		s.node = n
+		n.all = append(n.all, s)
+		n.pool <- s
+	}
+	return n
+}
+
+// Addr returns the address of this node.
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// Status returns a snapshot of the health of this node.
+func (n *Node) Status() NodeStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ids := make([][]byte, len(n.all))
+	for i, s := range n.all {
+		ids[i] = n.clientIds[s]
+	}
+	return NodeStatus{
+		Addr:        n.addr,
+		State:       n.state,
+		Failures:    n.failures,
+		Latency:     n.latency,
+		LastError:   n.lastErr,
+		LastErrorAt: n.lastErrAt,
+		Sessions:    n.sessions,
+		Outstanding: n.Outstanding(),
+		ClientIds:   ids,
+		Version:     n.version,
+	}
+}
+
+// Version returns the Riak version of this node, if known yet.
+func (n *Node) Version() ServerVersion {
+	if n == nil {
+		return ServerVersion{}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.version
+}
+
+// setVersion records the Riak version reported by the node.
+func (n *Node) setVersion(v ServerVersion) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.version = v
+}
+
+// setClientId records the client ID set on session s.
+func (n *Node) setClientId(s *Session, id []byte) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.clientIds == nil {
+		n.clientIds = make(map[*Session][]byte)
+	}
+	n.clientIds[s] = id
+}
+
+// Outstanding returns the number of sessions currently checked out from this node.
+func (n *Node) Outstanding() int {
+	return int(atomic.LoadInt64(&n.outstanding))
+}
+
+// Latency returns the moving average of request latency for this node.
+func (n *Node) Latency() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.latency
+}
+
+// take checks out an available session from this node.
+//
+// If wait is false only idle sessions are considered, otherwise it waits for a checked
+// out session to be released.  Returns nil if no session is available, ErrNodeRemoved
+// once the node has been removed and ErrClientClosed once the client is shut down,
+// including while waiting.
+func (n *Node) take(wait bool) (*Session, error) {
+	for i := 0; i < n.sessions; i++ {
+		var s *Session
+		if wait {
+			select {
+			case s = <-n.pool:
+			case <-n.removed:
+				return nil, ErrNodeRemoved
+			case <-n.client.shutdown:
+				return nil, ErrClientClosed
+			}
+		} else {
+			select {
+			case s = <-n.pool:
+			default:
+				return nil, nil
+			}
+		}
+		if n.isRemoved() {
+			// leave it to the drain
+			n.pool <- s
+			return nil, ErrNodeRemoved
+		}
+		if n.client.closed() {
+			// leave it to Shutdown
+			n.pool <- s
+			return nil, ErrClientClosed
+		}
+		if s.Available() {
+			atomic.AddInt64(&n.outstanding, 1)
+			return s, nil
+		}
+		n.pool <- s
+	}
+	return nil, nil
+}
+
+// remove marks this node as removed from the cluster, so no more sessions are taken from it.
+func (n *Node) remove() {
+	close(n.removed)
+}
+
+// drain closes the sessions of a removed node as they are released, until all of them are
+// closed or stop is closed.  Returns whether every session was closed.
+func (n *Node) drain(stop chan bool) bool {
+	defer close(n.drainDone)
+	for n.drainedCount() < n.sessions {
+		select {
+		case s := <-n.pool:
+			n.closeSession(s)
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// closeSession closes an idle session for good.
+func (n *Node) closeSession(s *Session) {
+	s.Close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.drained[s] = true
+}
+
+// drainedCount returns the number of sessions closed for good.
+func (n *Node) drainedCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.drained)
+}
+
+// isDrained reports whether s has been closed for good.
+func (n *Node) isDrained(s *Session) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.drained[s]
+}
+
+// isRemoved reports whether this node has been removed from the cluster.
+func (n *Node) isRemoved() bool {
+	select {
+	case <-n.removed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close marks this node as shut down.
+func (n *Node) close() {
+	atomic.StoreInt32(&n.closed, 1)
+}
+
+// isClosed reports whether this node has been shut down.
+func (n *Node) isClosed() bool {
+	if n == nil {
+		return false
+	}
+	return atomic.LoadInt32(&n.closed) == 1
+}
+
+// release marks a session as returned to this node.
+func (n *Node) release() {
+	if n == nil {
+		return
+	}
+	atomic.AddInt64(&n.outstanding, -1)
+}
+
+// allow reports whether sessions for this node may be handed out.
+func (n *Node) allow() bool {
+	if n == nil {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == BREAKER_CLOSED
+}
+
+// probe reports whether the node should be health checked, moving an open breaker
+// to half-open once its cooldown has passed.
+func (n *Node) probe() bool {
+	if n == nil {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state == BREAKER_OPEN {
+		if time.Since(n.openedAt) < n.client.breaker.Cooldown {
+			return false
+		}
+		n.state = BREAKER_HALF_OPEN
+	}
+	return true
+}
+
+// success records a successful request which took d.
+func (n *Node) success(d time.Duration) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if n.latency == 0 {
+		n.latency = d
+	} else {
+		n.latency = time.Duration(LatencyDecay*float64(d) + (1-LatencyDecay)*float64(n.latency))
+	}
+	n.failures = 0
+	recovered := n.state != BREAKER_CLOSED
+	n.state = BREAKER_CLOSED
+	n.mu.Unlock()
+	if recovered {
+		n.client.log().Log(LOG_INFO, "node back in rotation", Field{"node", n.addr})
+		n.client.observePool(PoolEvent{Type: POOL_NODE_UP, Node: n.addr})
+	}
+}
+
+// failure records a failed request or dial.
+func (n *Node) failure(err error) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.failures++
+	n.lastErr = err
+	n.lastErrAt = time.Now()
+	opened := false
+	switch {
+	case n.state == BREAKER_HALF_OPEN:
+		// probe failed, back out of rotation
+		n.state = BREAKER_OPEN
+		n.openedAt = n.lastErrAt
+	case n.state == BREAKER_CLOSED && n.client.breaker.Threshold > 0 && n.failures >= n.client.breaker.Threshold:
+		n.state = BREAKER_OPEN
+		n.openedAt = n.lastErrAt
+		opened = true
+	}
+	failures := n.failures
+	n.mu.Unlock()
+	if opened {
+		n.client.log().Log(LOG_WARN, "node pulled from rotation", Field{"node", n.addr}, Field{"failures", failures}, Field{"error", err})
+		n.client.observePool(PoolEvent{Type: POOL_NODE_DOWN, Node: n.addr, Err: err})
+	}
+}
@@ -0,0 +1,66 @@
+package riaken_core
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestNodeCircuitBreaker(t *testing.T) {
+	breaker := CircuitBreaker{Threshold: 3, Cooldown: time.Millisecond * 50}
+	node := newNode("127.0.0.1:10017", 0, &Client{breaker: breaker, logger: StdLogger(log.New(io.Discard, "", 0), LOG_ERROR)})
+	fail := errors.New("fail")
+
+	node.failure(fail)
+	node.failure(fail)
+	if !node.allow() {
+		t.Error("expected node to remain in rotation")
+	}
+	node.failure(fail)
+	if node.allow() {
+		t.Error("expected node to be pulled from rotation")
+	}
+	if node.probe() {
+		t.Error("expected no probe during cooldown")
+	}
+
+	time.Sleep(breaker.Cooldown)
+	if !node.probe() {
+		t.Error("expected probe after cooldown")
+	}
+	if st := node.Status(); st.State != BREAKER_HALF_OPEN {
+		t.Errorf("expected: %s, got: %s", BREAKER_HALF_OPEN, st.State)
+	}
+	node.failure(fail)
+	if st := node.Status(); st.State != BREAKER_OPEN {
+		t.Errorf("expected: %s, got: %s", BREAKER_OPEN, st.State)
+	}
+
+	time.Sleep(breaker.Cooldown)
+	node.probe()
+	node.success(time.Millisecond)
+	st := node.Status()
+	if st.State != BREAKER_CLOSED || st.Failures != 0 {
+		t.Errorf("expected closed breaker, got: %s with %d failures", st.State, st.Failures)
+	}
+	if st.LastError != fail {
+		t.Error("expected last error to be kept")
+	}
+}
+
+func TestNodeLatency(t *testing.T) {
+	node := newNode("127.0.0.1:10017", 0, &Client{})
+	node.success(time.Millisecond * 10)
+	node.success(time.Millisecond * 20)
+	if l := node.Status().Latency; l != time.Millisecond*12 {
+		t.Errorf("expected: %s, got: %s", time.Millisecond*12, l)
+	}
+	for i := 0; i < 100; i++ {
+		node.failure(errors.New("fail"))
+	}
+	if !node.allow() {
+		t.Error("expected disabled breaker to keep node in rotation")
+	}
+}
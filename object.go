@@ -1,18 +1,26 @@
 package riaken_core
 
 import (
+	"encoding/base64"
 	"errors"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/riaken/riaken-core/rpb"
 )
 
+// UpdateRetries is how many times Update retries after a conflicting write.
+const UpdateRetries int = 5
+
+var ErrObjectExists error = errors.New("object already exists")
+var ErrObjectModified error = errors.New("object has been modified")
+
 type Object struct {
-	bucket *Bucket // bucket this object is associated with
-	key    string  // key this object is associated with
-	vclock []byte  // vector clock
-	opts   interface{}
-	ct     []byte
+	bucket  *Bucket // bucket this object is associated with
+	key     string  // key this object is associated with
+	vclock  VClock  // vector clock
+	deleted bool    // whether the last Fetch found a tombstone
+	opts    interface{}
+	ct      []byte
 }
 
 func (o *Object) reset() {
@@ -35,8 +43,42 @@ func (o *Object) ContentType(ct []byte) {
 	o.ct = ct
 }
 
+// VClock returns the vector clock of this object, as read by Fetch or returned by Store.
+func (o *Object) VClock() VClock {
+	return o.vclock
+}
+
+// SetVClock sets the vector clock sent with Store and Delete.
+func (o *Object) SetVClock(vclock VClock) {
+	o.vclock = vclock
+}
+
+// VClockString returns the vector clock encoded as base64, suitable for an HTTP ETag.
+func (o *Object) VClockString() string {
+	return o.vclock.String()
+}
+
+// SetVClockString sets the vector clock from a string returned by VClockString.
+func (o *Object) SetVClockString(vclock string) error {
+	if vclock == "" {
+		o.vclock = nil
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(vclock)
+	if err != nil {
+		return err
+	}
+	o.vclock = data
+	return nil
+}
+
 // Fetch returns the data for this object at key.
-func (o *Object) Fetch() (*rpb.RpbGetResp, error) {
+//
+// Unless set otherwise through Do() the vector clock of a tombstone is requested, so
+// a deleted object can be recreated without resurrecting old siblings.  See Deleted.
+func (o *Object) Fetch() (_ *rpb.RpbGetResp, err error) {
+	sp := o.bucket.session.trace("Fetch", string(o.bucket.btype), o.bucket.name, o.key)
+	defer sp.end(&err)
 	defer o.reset()
 	opts := new(rpb.RpbGetReq)
 	if o.opts != nil {
@@ -51,22 +93,182 @@ func (o *Object) Fetch() (*rpb.RpbGetResp, error) {
 	if opts.Type == nil {
 		opts.Type = o.bucket.btype
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
+	if err := o.bucket.session.requireType(opts.Type); err != nil {
 		return nil, err
 	}
-	out, err := o.bucket.session.execute(Messages["GetReq"], in)
-	if err != nil {
+	if opts.Deletedvclock == nil {
+		opts.Deletedvclock = proto.Bool(true)
+	}
+	res := new(rpb.RpbGetResp)
+	if err := o.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["GetReq"],
+		Req:      opts,
+		RespCode: Messages["GetResp"],
+		Resp:     res,
+	}); err != nil {
+		return nil, err
+	}
+	if err := o.decrypt(res.Content); err != nil {
+		return nil, err
+	}
+	if err := decompress(res.Content); err != nil {
 		return nil, err
 	}
-	o.vclock = out.(*rpb.RpbGetResp).Vclock
-	return out.(*rpb.RpbGetResp), nil
+	o.vclock = res.Vclock
+	o.deleted = tombstone(res)
+	return res, nil
+}
+
+// Deleted reports whether the last Fetch found a tombstone rather than a missing or live object.
+//
+// The tombstone vector clock is kept, so a following Store replaces the tombstone cleanly.
+func (o *Object) Deleted() bool {
+	return o.deleted
+}
+
+// tombstone reports whether res describes a deleted object.
+func tombstone(res *rpb.RpbGetResp) bool {
+	if len(res.GetContent()) == 0 {
+		return res.Vclock != nil
+	}
+	for _, c := range res.GetContent() {
+		if !c.GetDeleted() {
+			return false
+		}
+	}
+	return true
 }
 
 // Store adds or replaces data for this object at key.
 //
 // It is up to the caller to make sure data is converted to []byte format.
-func (o *Object) Store(data []byte) (*rpb.RpbPutResp, error) {
+func (o *Object) Store(data []byte) (_ *rpb.RpbPutResp, err error) {
+	sp := o.bucket.session.trace("Store", string(o.bucket.btype), o.bucket.name, o.key)
+	defer sp.end(&err)
+	in, err := o.storeReq(data)
+	if err != nil {
+		return nil, err
+	}
+	out := new(rpb.RpbPutResp)
+	if err := o.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["PutReq"],
+		Req:      in,
+		RespCode: Messages["PutResp"],
+		Resp:     out,
+	}); err != nil {
+		return nil, err
+	}
+	if err := o.processStore(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// processStore keeps the vector clock returned when return_body or return_head was set,
+// and decrypts and decompresses any returned values.
+func (o *Object) processStore(resp *rpb.RpbPutResp) error {
+	o.deleted = false
+	if resp.Vclock != nil {
+		o.vclock = resp.Vclock
+	}
+	if err := o.decrypt(resp.Content); err != nil {
+		return err
+	}
+	return decompress(resp.Content)
+}
+
+// Create stores data only if nothing exists at key yet, otherwise ErrObjectExists is returned.
+func (o *Object) Create(data []byte) (*rpb.RpbPutResp, error) {
+	opts, err := o.putOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts.IfNoneMatch = proto.Bool(true)
+	out, err := o.Store(data)
+	if riakErrmsg(err) == "match_found" {
+		return nil, ErrObjectExists
+	}
+	return out, err
+}
+
+// StoreIfUnchanged stores data only if the object has not changed since it was fetched,
+// otherwise ErrObjectModified is returned.
+func (o *Object) StoreIfUnchanged(data []byte) (*rpb.RpbPutResp, error) {
+	if o.vclock == nil {
+		o.reset()
+		return nil, errors.New("No vclock, call Fetch() first")
+	}
+	opts, err := o.putOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts.IfNotModified = proto.Bool(true)
+	out, err := o.Store(data)
+	return out, modifiedErr(err)
+}
+
+// modifiedErr translates the Riak error of a put which failed because it was conditional on
+// the object being unchanged into ErrObjectModified.
+func modifiedErr(err error) error {
+	switch riakErrmsg(err) {
+	case "modified", "notfound":
+		// notfound means the object was deleted since it was fetched
+		return ErrObjectModified
+	}
+	return err
+}
+
+// Update does a read-modify-write of the object, passing the current value, or nil if there is
+// none, to fn and storing what it returns.  The write is conditional and on conflict the object
+// is fetched again and fn retried, up to UpdateRetries times.
+//
+// If the object has siblings fn is given the first one, and the write resolves them.
+func (o *Object) Update(fn func(old []byte) ([]byte, error)) (_ *rpb.RpbPutResp, err error) {
+	sp := o.bucket.session.trace("Update", string(o.bucket.btype), o.bucket.name, o.key)
+	defer sp.end(&err)
+	o.reset()
+	for i := 0; ; i++ {
+		sp.retries(i)
+		o.vclock = nil
+		res, err := o.Fetch()
+		if err != nil {
+			return nil, err
+		}
+		var old []byte
+		if len(res.GetContent()) > 0 {
+			old = res.GetContent()[0].GetValue()
+		}
+		data, err := fn(old)
+		if err != nil {
+			return nil, err
+		}
+		var out *rpb.RpbPutResp
+		if o.vclock == nil || o.deleted {
+			out, err = o.Create(data)
+		} else {
+			out, err = o.StoreIfUnchanged(data)
+		}
+		if (err != ErrObjectExists && err != ErrObjectModified) || i == UpdateRetries {
+			return out, err
+		}
+	}
+}
+
+// putOpts returns the RpbPutReq set with Do, setting a new one if there is none.
+func (o *Object) putOpts() (*rpb.RpbPutReq, error) {
+	if o.opts == nil {
+		o.opts = new(rpb.RpbPutReq)
+	}
+	opts, ok := o.opts.(*rpb.RpbPutReq)
+	if !ok {
+		o.reset()
+		return nil, errors.New("Called Do() with wrong opts. Should be RpbPutReq")
+	}
+	return opts, nil
+}
+
+// storeReq builds the RpbPutReq for Store.
+func (o *Object) storeReq(data []byte) (*rpb.RpbPutReq, error) {
 	defer o.reset()
 	opts := new(rpb.RpbPutReq)
 	if o.opts != nil {
@@ -83,6 +285,9 @@ func (o *Object) Store(data []byte) (*rpb.RpbPutResp, error) {
 	if opts.Type == nil {
 		opts.Type = o.bucket.btype
 	}
+	if err := o.bucket.session.requireType(opts.Type); err != nil {
+		return nil, err
+	}
 	if opts.Content == nil {
 		opts.Content = &rpb.RpbContent{
 			Value:       data,
@@ -91,27 +296,88 @@ func (o *Object) Store(data []byte) (*rpb.RpbPutResp, error) {
 	} else {
 		opts.Content.Value = data
 	}
+	if err := o.bucket.session.client().compress(opts.Content); err != nil {
+		return nil, err
+	}
+	if err := o.encrypt(opts.Content); err != nil {
+		return nil, err
+	}
 	if opts.Vclock == nil {
 		opts.Vclock = o.vclock
 	}
-	in, err := proto.Marshal(opts)
+	return opts, nil
+}
+
+// Delete removes the both the data and key for this object.
+func (o *Object) Delete() (_ bool, err error) {
+	sp := o.bucket.session.trace("Delete", string(o.bucket.btype), o.bucket.name, o.key)
+	defer sp.end(&err)
+	in, err := o.deleteReq()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	out, err := o.bucket.session.execute(Messages["PutReq"], in)
-	if err != nil {
-		return nil, err
+	if err := o.bucket.session.Execute(&ProtoCommand{
+		Code:     Messages["DelReq"],
+		Req:      in,
+		RespCode: Messages["DelResp"],
+	}); err != nil {
+		return false, err
 	}
-	return out.(*rpb.RpbPutResp), nil
+	o.deleted = true
+	return true, nil
 }
 
-// Delete removes the both the data and key for this object.
-func (o *Object) Delete() (bool, error) {
+// Quorum is a replica count for a read or write, or one of the symbolic QUORUM values.
+type Quorum uint32
+
+const (
+	QUORUM_ONE     Quorum = 4294967294
+	QUORUM_QUORUM  Quorum = 4294967293
+	QUORUM_ALL     Quorum = 4294967292
+	QUORUM_DEFAULT Quorum = 4294967291
+)
+
+// DeleteOpts are the quorums used by DeleteWith.  Zero values are left to the bucket defaults.
+type DeleteOpts struct {
+	RW Quorum
+	R  Quorum
+	W  Quorum
+	PR Quorum
+	PW Quorum
+	DW Quorum
+}
+
+// DeleteWith removes this object like Delete using the given quorums.
+func (o *Object) DeleteWith(quorums DeleteOpts) (bool, error) {
+	if o.opts == nil {
+		o.opts = new(rpb.RpbDelReq)
+	}
+	opts, ok := o.opts.(*rpb.RpbDelReq)
+	if !ok {
+		o.reset()
+		return false, errors.New("Called Do() with wrong opts. Should be RpbDelReq")
+	}
+	set := func(field **uint32, q Quorum) {
+		if q != 0 {
+			*field = proto.Uint32(uint32(q))
+		}
+	}
+	set(&opts.Rw, quorums.RW)
+	set(&opts.R, quorums.R)
+	set(&opts.W, quorums.W)
+	set(&opts.Pr, quorums.PR)
+	set(&opts.Pw, quorums.PW)
+	set(&opts.Dw, quorums.DW)
+	return o.Delete()
+}
+
+// deleteReq builds the RpbDelReq for Delete.
+func (o *Object) deleteReq() (*rpb.RpbDelReq, error) {
 	defer o.reset()
 	opts := new(rpb.RpbDelReq)
 	if o.opts != nil {
 		if _, ok := o.opts.(*rpb.RpbDelReq); !ok {
-			return false, errors.New("Called Do() with wrong opts. Should be RpbDelReq")
+			return nil, errors.New("Called Do() with wrong opts. Should be RpbDelReq")
 		} else {
 			opts = o.opts.(*rpb.RpbDelReq)
 		}
@@ -121,16 +387,11 @@ func (o *Object) Delete() (bool, error) {
 	if opts.Type == nil {
 		opts.Type = o.bucket.btype
 	}
+	if err := o.bucket.session.requireType(opts.Type); err != nil {
+		return nil, err
+	}
 	if opts.Vclock == nil {
 		opts.Vclock = o.vclock
 	}
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return false, err
-	}
-	out, err := o.bucket.session.execute(Messages["DelReq"], in)
-	if err != nil {
-		return false, err
-	}
-	return out.(bool), nil
+	return opts, nil
 }
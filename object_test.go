@@ -149,3 +149,235 @@ func TestObjectNoKey(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestObjectConditional(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	bucket := session.GetBucket("b1")
+	object := bucket.Object("cond1")
+	if _, err := object.Create([]byte("v1")); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := bucket.Object("cond1").Create([]byte("v1")); err != ErrObjectExists {
+		t.Errorf("expected: %v, got: %v", ErrObjectExists, err)
+	}
+
+	if _, err := object.Fetch(); err != nil {
+		t.Error(err.Error())
+	}
+	other := bucket.Object("cond1")
+	if _, err := other.Fetch(); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := other.StoreIfUnchanged([]byte("v2")); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := object.StoreIfUnchanged([]byte("v3")); err != ErrObjectModified {
+		t.Errorf("expected: %v, got: %v", ErrObjectModified, err)
+	}
+
+	if _, err := object.Update(func(old []byte) ([]byte, error) {
+		return append(old, '!'), nil
+	}); err != nil {
+		t.Error(err.Error())
+	}
+	data, err := object.Fetch()
+	if err != nil {
+		t.Error(err.Error())
+	} else if string(data.GetContent()[0].GetValue()) != "v2!" {
+		t.Errorf("got %s, expected v2!", string(data.GetContent()[0].GetValue()))
+	}
+	if _, err := object.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestObjectUpdateRetry(t *testing.T) {
+	puts := 0
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case Messages["GetReq"]:
+			out, _ := proto.Marshal(&rpb.RpbGetResp{
+				Content: []*rpb.RpbContent{{Value: []byte("1")}},
+				Vclock:  []byte("vclock"),
+			})
+			return Messages["GetResp"], out
+		case Messages["PutReq"]:
+			req := &rpb.RpbPutReq{}
+			proto.Unmarshal(in, req)
+			if !req.GetIfNotModified() || string(req.GetVclock()) != "vclock" {
+				t.Errorf("unexpected put: %v", req)
+			}
+			puts++
+			if puts == 1 {
+				out, _ := proto.Marshal(&rpb.RpbErrorResp{
+					Errmsg:  []byte("modified"),
+					Errcode: proto.Uint32(1),
+				})
+				return Messages["ErrorResp"], out
+			}
+		}
+		return code + 1, nil
+	})
+	defer stop()
+
+	calls := 0
+	_, err := session.GetBucket("b1").Object("o1").Update(func(old []byte) ([]byte, error) {
+		calls++
+		return append(old, '1'), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if calls != 2 || puts != 2 {
+		t.Errorf("expected: 2 calls and puts, got: %d calls, %d puts", calls, puts)
+	}
+}
+
+func TestObjectVClock(t *testing.T) {
+	var sent []string
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		req := &rpb.RpbPutReq{}
+		proto.Unmarshal(in, req)
+		sent = append(sent, string(req.GetVclock()))
+		out, _ := proto.Marshal(&rpb.RpbPutResp{Vclock: []byte("v" + string(rune('1'+len(sent))))})
+		return Messages["PutResp"], out
+	})
+	defer stop()
+
+	object := session.GetBucket("b1").Object("o1")
+	object.SetVClock([]byte("v1"))
+	for i := 0; i < 2; i++ {
+		if _, err := object.Do(&rpb.RpbPutReq{ReturnHead: proto.Bool(true)}).Store([]byte("data")); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if len(sent) != 2 || sent[0] != "v1" || sent[1] != "v2" {
+		t.Errorf("unexpected vclocks sent: %v", sent)
+	}
+	if string(object.VClock()) != "v3" {
+		t.Errorf("expected: v3, got: %s", object.VClock())
+	}
+
+	tag := object.VClockString()
+	other := session.GetBucket("b1").Object("o1")
+	if err := other.SetVClockString(tag); err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(other.VClock()) != "v3" {
+		t.Errorf("expected: v3, got: %s", other.VClock())
+	}
+	if err := other.SetVClockString("not base64!"); err == nil {
+		t.Error("expected an error for an invalid vclock string")
+	}
+}
+
+func TestObjectTombstone(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	bucket := session.GetBucket("b1")
+	object := bucket.Object("tomb1")
+	if _, err := object.Store([]byte("tomb1-data")); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := object.DeleteWith(DeleteOpts{RW: QUORUM_ALL}); err != nil {
+		t.Error(err.Error())
+	}
+	other := bucket.Object("tomb1")
+	if _, err := other.Fetch(); err != nil {
+		t.Error(err.Error())
+	}
+	if !other.Deleted() {
+		t.Error("expected a tombstone")
+	}
+	if _, err := other.Store([]byte("tomb1-data2")); err != nil {
+		t.Error(err.Error())
+	}
+	data, err := other.Fetch()
+	if err != nil {
+		t.Error(err.Error())
+	} else if len(data.GetContent()) != 1 {
+		t.Errorf("expected: 1 value, got: %d", len(data.GetContent()))
+	}
+	if _, err := other.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestObjectTombstoneFake(t *testing.T) {
+	var put *rpb.RpbPutReq
+	var del *rpb.RpbDelReq
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case Messages["GetReq"]:
+			req := &rpb.RpbGetReq{}
+			proto.Unmarshal(in, req)
+			if !req.GetDeletedvclock() {
+				t.Error("expected deletedvclock to be requested")
+			}
+			out, _ := proto.Marshal(&rpb.RpbGetResp{Vclock: []byte("tombstone")})
+			return Messages["GetResp"], out
+		case Messages["PutReq"]:
+			put = &rpb.RpbPutReq{}
+			proto.Unmarshal(in, put)
+		case Messages["DelReq"]:
+			del = &rpb.RpbDelReq{}
+			proto.Unmarshal(in, del)
+		}
+		return code + 1, nil
+	})
+	defer stop()
+
+	object := session.GetBucket("b1").Object("o1")
+	if _, err := object.Fetch(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !object.Deleted() {
+		t.Error("expected a tombstone")
+	}
+	if _, err := object.Store([]byte("data")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(put.GetVclock()) != "tombstone" || object.Deleted() {
+		t.Errorf("expected the tombstone vclock to be stored, got: %s", put.GetVclock())
+	}
+	if _, err := object.DeleteWith(DeleteOpts{RW: QUORUM_QUORUM, PW: 2}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if del.GetRw() != uint32(QUORUM_QUORUM) || del.GetPw() != 2 || del.Dw != nil || !object.Deleted() {
+		t.Errorf("unexpected delete: %v", del)
+	}
+}
+
+func TestRiakErrorNotTranslated(t *testing.T) {
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		msg := "notfound"
+		if code == Messages["PutReq"] {
+			msg = "match_found"
+		}
+		out, _ := proto.Marshal(&rpb.RpbErrorResp{
+			Errmsg:  []byte(msg),
+			Errcode: proto.Uint32(1),
+		})
+		return Messages["ErrorResp"], out
+	})
+	defer stop()
+
+	_, err := session.Query().Search([]byte("index"), []byte("q"))
+	if err == nil || err.Error() != "riak error [1]: notfound" {
+		t.Errorf("expected the riak error, got: %v", err)
+	}
+	_, err = session.GetBucket("b1").Object("o1").Store([]byte("data"))
+	if err == nil || err.Error() != "riak error [1]: match_found" {
+		t.Errorf("expected the riak error, got: %v", err)
+	}
+	if _, err := session.GetBucket("b1").Object("o1").Create([]byte("data")); err != ErrObjectExists {
+		t.Errorf("expected: %v, got: %v", ErrObjectExists, err)
+	}
+}
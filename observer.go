@@ -0,0 +1,127 @@
+package riaken_core
+
+import (
+	"expvar"
+	"time"
+)
+
+// RequestEvent describes a single request/response cycle with Riak.
+type RequestEvent struct {
+	Op       string        // request name from Codes, e.g. "GetReq"
+	Node     string        // node address
+	Duration time.Duration // time from write until the response was read
+	BytesOut int           // size of the request frame, including its 4 byte length prefix
+	BytesIn  int           // size of the response frame, including its 4 byte length prefix
+	Err      error         // connection failure or Riak error response, if any
+}
+
+// PoolEventType identifies what happened in a PoolEvent.
+type PoolEventType int
+
+const (
+	POOL_CHECKOUT  PoolEventType = 0 // a session was handed out
+	POOL_DIAL      PoolEventType = 1 // a session dialed its node
+	POOL_REDIAL    PoolEventType = 2 // a lost session redialed its node
+	POOL_NODE_DOWN PoolEventType = 3 // a node was pulled from rotation
+	POOL_NODE_UP   PoolEventType = 4 // a node was put back into rotation
+)
+
+func (p PoolEventType) String() string {
+	switch p {
+	case POOL_CHECKOUT:
+		return "checkout"
+	case POOL_DIAL:
+		return "dial"
+	case POOL_REDIAL:
+		return "redial"
+	case POOL_NODE_DOWN:
+		return "node_down"
+	case POOL_NODE_UP:
+		return "node_up"
+	}
+	return "unknown"
+}
+
+// PoolEvent describes a change to the session pool or node health.
+type PoolEvent struct {
+	Type PoolEventType
+	Node string        // node address
+	Wait time.Duration // time spent waiting for a session, for POOL_CHECKOUT
+	Err  error         // dial failure, or the failure which pulled the node
+}
+
+// Observer is notified of every request and pool event.  It is called synchronously
+// from the session doing the work and may be called concurrently.
+type Observer interface {
+	ObserveRequest(e RequestEvent)
+	ObservePool(e PoolEvent)
+}
+
+// observeRequest passes e to the observer, if any.
+func (c *Client) observeRequest(e RequestEvent) {
+	if c != nil && c.observer != nil {
+		c.observer.ObserveRequest(e)
+	}
+}
+
+// observePool passes e to the observer, if any.
+func (c *Client) observePool(e PoolEvent) {
+	if c != nil && c.observer != nil {
+		c.observer.ObservePool(e)
+	}
+}
+
+// ExpvarObserver collects counters per opcode and pool event and publishes them with expvar.
+//
+// The published map holds the sub maps requests, errors, latency_us, bytes_in and bytes_out keyed
+// by opcode name, pool keyed by pool event type, and the total checkout_wait_us.
+type ExpvarObserver struct {
+	Requests     *expvar.Map
+	Errors       *expvar.Map
+	Latency      *expvar.Map // microseconds
+	BytesIn      *expvar.Map
+	BytesOut     *expvar.Map
+	Pool         *expvar.Map
+	CheckoutWait *expvar.Int // microseconds
+}
+
+// NewExpvarObserver returns a new ExpvarObserver published under name.
+//
+// Like expvar.Publish it panics if name is already in use.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	o := &ExpvarObserver{
+		Requests:     new(expvar.Map).Init(),
+		Errors:       new(expvar.Map).Init(),
+		Latency:      new(expvar.Map).Init(),
+		BytesIn:      new(expvar.Map).Init(),
+		BytesOut:     new(expvar.Map).Init(),
+		Pool:         new(expvar.Map).Init(),
+		CheckoutWait: new(expvar.Int),
+	}
+	m := expvar.NewMap(name)
+	m.Set("requests", o.Requests)
+	m.Set("errors", o.Errors)
+	m.Set("latency_us", o.Latency)
+	m.Set("bytes_in", o.BytesIn)
+	m.Set("bytes_out", o.BytesOut)
+	m.Set("pool", o.Pool)
+	m.Set("checkout_wait_us", o.CheckoutWait)
+	return o
+}
+
+func (o *ExpvarObserver) ObserveRequest(e RequestEvent) {
+	o.Requests.Add(e.Op, 1)
+	if e.Err != nil {
+		o.Errors.Add(e.Op, 1)
+	}
+	o.Latency.Add(e.Op, int64(e.Duration/time.Microsecond))
+	o.BytesIn.Add(e.Op, int64(e.BytesIn))
+	o.BytesOut.Add(e.Op, int64(e.BytesOut))
+}
+
+func (o *ExpvarObserver) ObservePool(e PoolEvent) {
+	o.Pool.Add(e.Type.String(), 1)
+	if e.Type == POOL_CHECKOUT {
+		o.CheckoutWait.Add(int64(e.Wait / time.Microsecond))
+	}
+}
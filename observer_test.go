@@ -0,0 +1,132 @@
+package riaken_core
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testObserver struct {
+	mu       sync.Mutex
+	requests []RequestEvent
+	pool     []PoolEvent
+}
+
+func (o *testObserver) ObserveRequest(e RequestEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, e)
+}
+
+func (o *testObserver) ObservePool(e PoolEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pool = append(o.pool, e)
+}
+
+func (o *testObserver) count(t PoolEventType) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, e := range o.pool {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClientObserver(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	observer := &testObserver{}
+	client := NewClient([]string{addr}, 2)
+	client.SetObserver(observer)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	session := client.Session()
+	if !session.Ping() {
+		t.Error("no ping response")
+	}
+	session.Release()
+
+	if n := observer.count(POOL_DIAL); n != 2 {
+		t.Errorf("expected: %d dials, got: %d", 2, n)
+	}
+	if n := observer.count(POOL_CHECKOUT); n != 1 {
+		t.Errorf("expected: %d checkouts, got: %d", 1, n)
+	}
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	// dialing also asks each session for the server info
+	var pings []RequestEvent
+	for _, e := range observer.requests {
+		if e.Op == "PingReq" {
+			pings = append(pings, e)
+		}
+	}
+	if len(pings) != 1 || len(observer.requests) != 3 {
+		t.Fatalf("expected: %d pings of %d requests, got: %d of %d", 1, 3, len(pings), len(observer.requests))
+	}
+	e := pings[0]
+	if e.Op != "PingReq" || e.Node != addr || e.BytesOut != 5 || e.BytesIn != 5 || e.Err != nil {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestSessionObserverStream(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	observer := &testObserver{}
+	client := NewClient([]string{addr}, 1)
+	client.SetObserver(observer)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	// a response read as the continuation of a stream is reported under the request of the stream
+	if err := session.write(rpbFrame(nil, Messages["PingReq"], nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := session.executeRead(Messages["ListKeysReq"]); err != nil {
+		t.Fatal(err.Error())
+	}
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	e := observer.requests[len(observer.requests)-1]
+	if e.Op != "ListKeysReq" || e.BytesIn != 5 || e.Err != nil {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	// expvar names can only be published once per process, so -count > 1 needs a fresh one
+	name := fmt.Sprintf("riaken_test_%d", time.Now().UnixNano())
+	o := NewExpvarObserver(name)
+	o.ObserveRequest(RequestEvent{Op: "GetReq", BytesIn: 10, BytesOut: 20})
+	o.ObserveRequest(RequestEvent{Op: "GetReq", Err: ErrZeroLength})
+	o.ObservePool(PoolEvent{Type: POOL_CHECKOUT})
+	o.ObservePool(PoolEvent{Type: POOL_NODE_DOWN})
+
+	m := expvar.Get(name).(*expvar.Map)
+	if v := m.Get("requests").(*expvar.Map).Get("GetReq").String(); v != "2" {
+		t.Errorf("expected: %s, got: %s", "2", v)
+	}
+	if v := m.Get("errors").(*expvar.Map).Get("GetReq").String(); v != "1" {
+		t.Errorf("expected: %s, got: %s", "1", v)
+	}
+	if v := m.Get("bytes_out").(*expvar.Map).Get("GetReq").String(); v != "20" {
+		t.Errorf("expected: %s, got: %s", "20", v)
+	}
+	if v := m.Get("pool").(*expvar.Map).Get("node_down").String(); v != "1" {
+		t.Errorf("expected: %s, got: %s", "1", v)
+	}
+}
@@ -3,7 +3,6 @@ package riaken_core
 import (
 	"errors"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/riaken/riaken-core/rpb"
 )
 
@@ -37,20 +36,17 @@ func (q *Query) Do(opts interface{}) *Query {
 //		}
 //		result = append(result, out.GetResponse()...)
 //	}
-func (q *Query) MapReduce(req, ct []byte) (*rpb.RpbMapRedResp, error) {
+func (q *Query) MapReduce(req, ct []byte) (_ *rpb.RpbMapRedResp, err error) {
+	sp := q.session.trace("MapReduce", "", "", "")
+	defer sp.end(&err)
 	opts := &rpb.RpbMapRedReq{
 		Request:     req,
 		ContentType: ct,
 	}
-	var err error
 	var out interface{}
 	switch q.streamState {
 	case 0:
-		in, err := proto.Marshal(opts)
-		if err != nil {
-			return nil, err
-		}
-		out, err = q.session.execute(Messages["MapRedReq"], in)
+		out, err = q.session.execute(Messages["MapRedReq"], Messages["MapRedResp"], opts)
 		if err != nil {
 			return nil, err
 		}
@@ -58,7 +54,7 @@ func (q *Query) MapReduce(req, ct []byte) (*rpb.RpbMapRedResp, error) {
 
 		// Fall through and do an initial read as well
 	case 1:
-		out, err = q.session.executeRead()
+		out, err = q.session.executeRead(Messages["MapRedReq"])
 		if err != nil {
 			return nil, err
 		}
@@ -75,7 +71,9 @@ func (q *Query) MapReduce(req, ct []byte) (*rpb.RpbMapRedResp, error) {
 // Set stream to true when calling Do(RpbIndexReq).SecondaryIndexes().
 //
 // Note: storage_backend must be set to leveldb in riak.conf.
-func (q *Query) SecondaryIndexes(bucket, index, key, start, end []byte, maxResults uint32, continuation []byte) (*rpb.RpbIndexResp, error) {
+func (q *Query) SecondaryIndexes(bucket, index, key, start, end []byte, maxResults uint32, continuation []byte) (_ *rpb.RpbIndexResp, err error) {
+	sp := q.session.trace("SecondaryIndexes", "", string(bucket), string(key))
+	defer sp.end(&err)
 	defer q.reset()
 	opts := &rpb.RpbIndexReq{}
 	if q.opts != nil {
@@ -87,6 +85,14 @@ func (q *Query) SecondaryIndexes(bucket, index, key, start, end []byte, maxResul
 	}
 	opts.Bucket = bucket
 	opts.Index = index
+	if err := q.session.requireType(opts.Type); err != nil {
+		return nil, err
+	}
+	if maxResults > 0 || continuation != nil {
+		if err := q.session.require(FEATURE_2I_PAGINATION); err != nil {
+			return nil, err
+		}
+	}
 	if maxResults > 0 {
 		opts.MaxResults = &maxResults
 	}
@@ -102,21 +108,16 @@ func (q *Query) SecondaryIndexes(bucket, index, key, start, end []byte, maxResul
 		opts.RangeMin = start
 		opts.RangeMax = end
 	}
-	var err error
 	var out interface{}
 	switch q.streamState {
 	case 0:
-		in, err := proto.Marshal(opts)
-		if err != nil {
-			return nil, err
-		}
-		out, err = q.session.execute(Messages["IndexReq"], in)
+		out, err = q.session.execute(Messages["IndexReq"], Messages["IndexResp"], opts)
 		if err != nil {
 			return nil, err
 		}
 		q.streamState = 1
 	case 1:
-		out, err = q.session.executeRead()
+		out, err = q.session.executeRead(Messages["IndexReq"])
 		if err != nil {
 			return nil, err
 		}
@@ -130,7 +131,9 @@ func (q *Query) SecondaryIndexes(bucket, index, key, start, end []byte, maxResul
 // Search retrieves a list of documents.
 //
 // Note: riak_search may need to be enabled in app.config.
-func (q *Query) Search(index, query []byte) (*rpb.RpbSearchQueryResp, error) {
+func (q *Query) Search(index, query []byte) (_ *rpb.RpbSearchQueryResp, err error) {
+	sp := q.session.trace("Search", "", "", "")
+	defer sp.end(&err)
 	defer q.reset()
 	opts := new(rpb.RpbSearchQueryReq)
 	if q.opts != nil {
@@ -142,13 +145,14 @@ func (q *Query) Search(index, query []byte) (*rpb.RpbSearchQueryResp, error) {
 	}
 	opts.Q = query
 	opts.Index = index
-	in, err := proto.Marshal(opts)
-	if err != nil {
-		return nil, err
-	}
-	out, err := q.session.execute(Messages["SearchQueryReq"], in)
-	if err != nil {
+	out := new(rpb.RpbSearchQueryResp)
+	if err := q.session.Execute(&ProtoCommand{
+		Code:     Messages["SearchQueryReq"],
+		Req:      opts,
+		RespCode: Messages["SearchQueryResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	return out.(*rpb.RpbSearchQueryResp), nil
+	return out, nil
 }
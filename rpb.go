@@ -1,10 +1,10 @@
 package riaken_core
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/riaken/riaken-core/rpb"
@@ -91,9 +91,11 @@ func rpbRead(data []byte) (interface{}, error) {
 	if len(data) == 0 {
 		return nil, ErrZeroLength
 	}
+	return rpbDecode(data[0], data[1:])
+}
 
-	code := data[0] // rpb code
-	data = data[1:] // remaining data, if any
+// RpbDecode decodes the body of a Riak response with the given code.
+func rpbDecode(code byte, data []byte) (interface{}, error) {
 	switch code {
 	case Messages["ErrorResp"]:
 		out := &rpb.RpbErrorResp{}
@@ -240,19 +242,28 @@ func rpbRead(data []byte) (interface{}, error) {
 	return nil, errors.New("invalid Rpb code specified")
 }
 
-// RpbWrite returns the correct data to write to a Riak rpb connection.
-func rpbWrite(code byte, data []byte) ([]byte, error) {
-	ml := new(bytes.Buffer)
-	binary.Write(ml, binary.BigEndian, int32(len(data)+1)) // +1 for msg code
-	mc := new(bytes.Buffer)
-	binary.Write(mc, binary.BigEndian, int8(code))
-	buf := []byte(ml.Bytes())
-	buf = append(buf, mc.Bytes()...)
+// RpbFrame appends the frame for a request with code and marshaled body data to buf.
+func rpbFrame(buf []byte, code byte, data []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, code)
 	buf = append(buf, data...)
-	return buf, nil
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(buf)-start-4)) // length includes the msg code
+	return buf
 }
 
 // RpbRiakError converts a Riak RpbErrorResp into a Go error.
 func rpbRiakError(err *rpb.RpbErrorResp) error {
 	return errors.New(fmt.Sprintf("riak error [%d]: %s", err.GetErrcode(), err.GetErrmsg()))
 }
+
+// riakErrmsg returns the Riak error message of an error built by rpbRiakError, or "" for any other error.
+func riakErrmsg(err error) string {
+	if err == nil || !strings.HasPrefix(err.Error(), "riak error [") {
+		return ""
+	}
+	msg := err.Error()
+	if i := strings.Index(msg, "]: "); i >= 0 {
+		return msg[i+3:]
+	}
+	return ""
+}
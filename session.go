@@ -1,14 +1,16 @@
 package riaken_core
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/riaken/riaken-core/rpb"
@@ -18,11 +20,18 @@ var ErrCannotRead error = errors.New("cannot read from a non-active or closed co
 var ErrCannotWrite error = errors.New("cannot write to a non-active or closed connection")
 
 type Session struct {
-	addr    string        // address this node is associated with
-	conn    *net.TCPConn  // connection
-	active  bool          // whether connection is active or not
-	cluster chan *Session // access to the client's cluster channel
-	debug   bool          // debugging info
+	addr     string          // address this node is associated with
+	conn     net.Conn        // connection, opened by the client Dialer
+	active   bool            // whether connection is active or not
+	cluster  chan *Session   // access to the node's session pool
+	node     *Node           // health of the node this session is connected to
+	ctx      context.Context // parent of trace spans set by WithContext, or the active span
+	span     *span           // active trace span, if any
+	clientId []byte          // client ID to set again after a redial
+	reader   *bufio.Reader   // buffered reads from conn
+	head     [4]byte         // frame header being read
+	wbuf     proto.Buffer    // request frames being written
+	connMu   sync.Mutex      // guards setting conn against forceClose
 }
 
 func NewSession(cluster chan *Session, addr string) *Session {
@@ -32,44 +41,76 @@ func NewSession(cluster chan *Session, addr string) *Session {
 	}
 }
 
+// client returns the client this session belongs to, or nil for a standalone session.
+func (s *Session) client() *Client {
+	if s.node == nil {
+		return nil
+	}
+	return s.node.client
+}
+
+// log returns the logger of the client this session belongs to.
+func (s *Session) log() Logger {
+	return s.client().log()
+}
+
 // Dial attempts to connect to the Riak node.
 func (s *Session) Dial() error {
-	var err error
-	addr, err := net.ResolveTCPAddr("tcp", s.addr)
-	if err != nil {
-		return err
+	return s.dial(POOL_DIAL)
+}
+
+// dial connects to the Riak node, reporting it to the observer as event.
+func (s *Session) dial(event PoolEventType) error {
+	ctx := context.Background()
+	if t := s.config().DialTimeout; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
 	}
-	s.conn, err = net.DialTCP("tcp", nil, addr)
+	conn, err := s.dialer()(ctx, s.addr)
+	s.connMu.Lock()
+	s.conn = conn
+	s.connMu.Unlock()
 	if err != nil {
-		if s.debug {
-			log.Print(err.Error())
-		}
+		s.node.failure(err)
+		s.log().Log(LOG_INFO, "dial failed", Field{"node", s.addr}, Field{"error", err})
 		s.Close()
 	} else {
-		if s.debug {
-			log.Printf("connected to: %s", s.addr)
-		}
-		s.conn.SetKeepAlive(true)
+		s.log().Log(LOG_DEBUG, "connected", Field{"node", s.addr})
+		s.reader = bufio.NewReader(s.conn)
 		s.active = true
+		s.applyClientId()
+		s.applyServerInfo()
 	}
+	s.client().observePool(PoolEvent{Type: event, Node: s.addr, Err: err})
 	return err
 }
 
+// applyClientId sets the client ID on a new connection, from the client strategy or
+// else the ID last set on this session.
+func (s *Session) applyClientId() {
+	id := s.clientId
+	if c := s.client(); c != nil && c.clientId != nil {
+		id = c.clientId(s.addr)
+	}
+	if id == nil {
+		return
+	}
+	if _, err := s.SetClientId(id); err != nil {
+		s.log().Log(LOG_WARN, "setting client id failed", Field{"node", s.addr}, Field{"error", err})
+	}
+}
+
 // check verifies the session is still connected and the Riak node can be accessed.
 func (s *Session) check() {
-	if s.debug {
-		log.Printf("state for %s - active: %t", s.addr, s.active)
-	}
+	s.log().Log(LOG_DEBUG, "session state", Field{"node", s.addr}, Field{"active", s.active})
 	if !s.active {
-		if s.debug {
-			log.Printf("redialing: %s", s.addr)
-		}
+		s.log().Log(LOG_INFO, "redialing", Field{"node", s.addr})
 		if s.conn != nil {
 			s.conn.Close()
 		}
-		if err := s.Dial(); err != nil {
-			log.Print(err.Error())
-		}
+		// dial logs its own failure
+		s.dial(POOL_REDIAL)
 	}
 	s.active = s.Ping()
 }
@@ -77,7 +118,7 @@ func (s *Session) check() {
 func (s *Session) Available() bool {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Println("Available: session paniced")
+			s.log().Log(LOG_ERROR, "session panicked", Field{"method", "Available"}, Field{"node", s.addr}, Field{"error", err})
 		}
 	}()
 	return (s.conn != nil && s.active)
@@ -86,9 +127,15 @@ func (s *Session) Available() bool {
 func (s *Session) Release() {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Println("Release: session paniced")
+			s.log().Log(LOG_ERROR, "session panicked", Field{"method", "Release"}, Field{"node", s.addr}, Field{"error", err})
 		}
 	}()
+	s.ctx = nil
+	s.node.release()
+	if s.node.isClosed() {
+		// the client was closed while the session was checked out
+		s.Close()
+	}
 	s.cluster <- s
 }
 
@@ -100,34 +147,56 @@ func (s *Session) Close() {
 	}
 }
 
+// forceClose closes the connection of a session which may be in use by another goroutine,
+// causing any pending read or write to fail.
+func (s *Session) forceClose() {
+	s.connMu.Lock()
+	conn := s.conn
+	s.connMu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
 // read response from the network connection.
+//
+// The returned frame comes from a pool and is handed back with putFrame once decoded.
 func (s *Session) read() ([]byte, error) {
 	if !s.Available() {
 		return nil, ErrCannotRead
 	}
-	buf := make([]byte, 4)
-	var size int32
+	if s.reader == nil {
+		s.reader = bufio.NewReader(s.conn)
+	}
+	s.setReadDeadline()
 	// first 4 bytes are always size of message
-	if count, err := io.ReadFull(s.conn, buf); err == nil && count == 4 {
-		sbuf := bytes.NewBuffer(buf)
-		binary.Read(sbuf, binary.BigEndian, &size)
-		data := make([]byte, size)
-		// read rest of message and return it if no errors
-		count, err := io.ReadFull(s.conn, data)
-		if err != nil {
-			if err == syscall.EPIPE {
-				s.conn.Close()
-			}
+	if _, err := io.ReadFull(s.reader, s.head[:]); err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			// a late response would be read by the next request
+			s.conn.Close()
 			s.active = false
 			return nil, err
 		}
-		if count != int(size) {
-			s.active = false
-			return nil, errors.New(fmt.Sprintf("data length: %d, only read: %d", len(data), count))
+		return nil, nil
+	}
+	size := binary.BigEndian.Uint32(s.head[:])
+	if max := s.maxFrame(); int64(size) > int64(max) {
+		s.log().Log(LOG_WARN, "response frame too large", Field{"node", s.addr}, Field{"size", size}, Field{"max", max})
+		s.conn.Close()
+		s.active = false
+		return nil, &FrameSizeError{Size: size, Max: max}
+	}
+	data := getFrame(int(size))
+	// read rest of message and return it if no errors
+	if _, err := io.ReadFull(s.reader, data); err != nil {
+		putFrame(data)
+		if err == syscall.EPIPE {
+			s.conn.Close()
 		}
-		return data, nil
+		s.active = false
+		return nil, err
 	}
-	return nil, nil
+	return data, nil
 }
 
 // write data to network connection.
@@ -135,6 +204,7 @@ func (s *Session) write(data []byte) error {
 	if !s.Available() {
 		return ErrCannotWrite
 	}
+	s.setWriteDeadline()
 	count, err := s.conn.Write(data)
 	if err != nil {
 		if err == syscall.EPIPE {
@@ -150,42 +220,116 @@ func (s *Session) write(data []byte) error {
 	return nil
 }
 
-// execute does the full request/response cycle on a command using a single Node connection instance.
-func (s *Session) execute(code byte, in []byte) (interface{}, error) {
-	req, err := rpbWrite(code, in)
+// Execute does the full request/response cycle of cmd using a single Node connection instance.
+//
+// Riak error responses are returned as errors, and a response with another code than
+// cmd.ResponseCode() as ErrUnexpectedResponse, after which the session is no longer usable.
+func (s *Session) Execute(cmd Command) error {
+	if s.node.isClosed() {
+		return ErrClientClosed
+	}
+	code := cmd.RequestCode()
+	if err := s.requireCode(code); err != nil {
+		return err
+	}
+	defer s.releaseWrite()
+	// reserve the header and marshal right behind it
+	req := append(s.wbuf.Bytes()[:0], 0, 0, 0, 0, code)
+	req, err := cmd.Marshal(req)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4)) // length includes the msg code
+	s.wbuf.SetBuf(req)
+
+	start := time.Now()
+	size, rerr, err := s.roundTrip(req, cmd)
+	elapsed := time.Since(start)
+	s.span.request(code, size)
+	event := RequestEvent{
+		Op:       Codes[code],
+		Node:     s.addr,
+		Duration: elapsed,
+		BytesOut: len(req),
+		BytesIn:  size,
+		Err:      rerr,
+	}
 	if err != nil {
-		return nil, err
+		event.Err = err
+		s.client().observeRequest(event)
+		s.node.failure(err)
+		s.log().Log(LOG_INFO, "request failed", Field{"node", s.addr}, Field{"opcode", Codes[code]}, Field{"latency", elapsed}, Field{"error", err})
+		return err
 	}
+	s.client().observeRequest(event)
+	// Riak answered, even if only with an error response
+	s.node.success(elapsed)
+	return rerr
+}
 
-	if err := s.write(req); err != nil {
-		return nil, err
+// execute runs a request whose response is decoded by rpbRead, such as the first
+// response of a stream continued with executeRead.
+//
+// A nil msg sends a request without a body.
+func (s *Session) execute(code, resp byte, msg proto.Message) (interface{}, error) {
+	cmd := &rpbCommand{
+		ProtoCommand: ProtoCommand{
+			Code:     code,
+			Req:      msg,
+			RespCode: resp,
+		},
 	}
+	err := s.Execute(cmd)
+	return cmd.out, err
+}
 
+// roundTrip writes req and reads back the response to cmd, returning its size.
+//
+// Connection failures are returned as err, while a Riak error response is returned as rerr
+// since the node itself is still healthy.
+func (s *Session) roundTrip(req []byte, cmd Command) (size int, rerr error, err error) {
+	if err := s.write(req); err != nil {
+		return 0, nil, err
+	}
 	resp, err := s.read()
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-
-	data, err := rpbRead(resp)
+	size = frameSize(resp)
+	err = decode(resp, cmd)
+	putFrame(resp)
 	if err != nil {
 		// For some reason the connection isn't responding, set to inactive.
-		// This could be an insufficient number of vnodes error, etc.
-		if err == ErrZeroLength {
+		// This could be an insufficient number of vnodes error, etc.  An unexpected
+		// response means the stream is out of step with the requests.
+		if err == ErrZeroLength || err == ErrUnexpectedResponse {
 			s.active = false
+			return size, nil, err
 		}
-		return nil, err
+		return size, err, nil
 	}
-	return data, nil
+	return size, nil, nil
 }
 
-// executeRead continues to read streaming value from the same connection.
-func (s *Session) executeRead() (interface{}, error) {
+// executeRead continues to read streaming value from the same connection, for the
+// stream started by the request code.
+func (s *Session) executeRead(code byte) (interface{}, error) {
+	start := time.Now()
 	resp, err := s.read()
+	event := RequestEvent{
+		Op:       Codes[code],
+		Node:     s.addr,
+		Duration: time.Since(start),
+		BytesIn:  frameSize(resp),
+		Err:      err,
+	}
+	s.client().observeRequest(event)
 	if err != nil {
 		return nil, err
 	}
 
 	data, err := rpbRead(resp)
+	putFrame(resp)
 	if err != nil {
 		// For some reason the connection isn't responding, set to inactive.
 		// This could be an insufficient number of vnodes error, etc.
@@ -197,6 +341,104 @@ func (s *Session) executeRead() (interface{}, error) {
 	return data, nil
 }
 
+// pipelineReq is a single request frame sent by pipeline.
+type pipelineReq struct {
+	code byte   // rpb request code
+	in   []byte // marshaled request
+}
+
+// pipeline writes every request back to back on the connection and reads the responses in order.
+//
+// Riak error responses are returned per request in errs, while a connection failure aborts the whole
+// pipeline and is returned as err.  Writing happens in the background so a large pipeline cannot
+// deadlock against Riak filling up the read side of the connection.
+func (s *Session) pipeline(reqs []pipelineReq) (out []interface{}, errs []error, err error) {
+	if s.node.isClosed() {
+		return nil, nil, ErrClientClosed
+	}
+	if !s.Available() {
+		return nil, nil, ErrCannotWrite
+	}
+	defer s.releaseWrite()
+	buf := s.wbuf.Bytes()[:0]
+	sizes := make([]int, len(reqs))
+	for i, r := range reqs {
+		if err := s.requireCode(r.code); err != nil {
+			return nil, nil, err
+		}
+		size := len(buf)
+		buf = rpbFrame(buf, r.code, r.in)
+		sizes[i] = len(buf) - size
+	}
+	s.wbuf.SetBuf(buf)
+
+	start := time.Now()
+	conn := s.conn
+	s.setWriteDeadline()
+	werr := make(chan error, 1)
+	go func() {
+		count, err := conn.Write(buf)
+		if err == nil && count != len(buf) {
+			err = errors.New(fmt.Sprintf("data length: %d, only wrote: %d", len(buf), count))
+		}
+		if err != nil {
+			// unblock the reader
+			conn.Close()
+		}
+		werr <- err
+	}()
+
+	out = make([]interface{}, len(reqs))
+	errs = make([]error, len(reqs))
+	for i, r := range reqs {
+		resp, rerr := s.read()
+		if rerr == nil {
+			out[i], errs[i] = rpbRead(resp)
+			if errs[i] == ErrZeroLength {
+				rerr = errs[i]
+			}
+		}
+		event := RequestEvent{
+			Op:       Codes[r.code],
+			Node:     s.addr,
+			Duration: time.Since(start),
+			BytesOut: sizes[i],
+			BytesIn:  frameSize(resp),
+			Err:      errs[i],
+		}
+		putFrame(resp)
+		if rerr != nil {
+			event.Err = rerr
+		}
+		s.client().observeRequest(event)
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+	if err != nil {
+		// the writer may still be blocked on Riak, which stopped being read
+		conn.Close()
+	}
+	if e := <-werr; e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		s.active = false
+		s.node.failure(err)
+		return nil, nil, err
+	}
+	s.node.success(time.Since(start))
+	return out, errs, nil
+}
+
+// Batch returns a new batch of pipelined writes on this session.
+func (s *Session) Batch() *Batch {
+	return &Batch{
+		session: s,
+	}
+}
+
 // GetBucket returns a new bucket to interact with on this session.
 func (s *Session) GetBucket(name string) *Bucket {
 	return &Bucket{
@@ -217,11 +459,15 @@ func (s *Session) Query() *Query {
 //
 // Riak Docs - Caution: This call can be expensive for the server - do not use in performance sensitive code.
 func (s *Session) ListBuckets() ([]*Bucket, error) {
-	out, err := s.execute(Messages["ListBucketsReq"], nil)
-	if err != nil {
+	out := new(rpb.RpbListBucketsResp)
+	if err := s.Execute(&ProtoCommand{
+		Code:     Messages["ListBucketsReq"],
+		RespCode: Messages["ListBucketsResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	blist := out.(*rpb.RpbListBucketsResp).GetBuckets()
+	blist := out.GetBuckets()
 	buckets := make([]*Bucket, len(blist))
 	for i, name := range blist {
 		buckets[i] = s.GetBucket(string(name))
@@ -233,43 +479,54 @@ func (s *Session) ListBuckets() ([]*Bucket, error) {
 //
 // This method directly influences the state of the node attached to this session.
 func (s *Session) Ping() bool {
-	check, err := s.execute(Messages["PingReq"], nil)
-	if err != nil {
+	if err := s.Execute(&ProtoCommand{
+		Code:     Messages["PingReq"],
+		RespCode: Messages["PingResp"],
+	}); err != nil {
 		return false
 	}
-	return check.(bool)
+	return true
 }
 
 // GetClientId gets the id set for this client.
 func (s *Session) GetClientId() (*rpb.RpbGetClientIdResp, error) {
-	out, err := s.execute(Messages["GetClientIdReq"], nil)
-	if err != nil {
+	out := new(rpb.RpbGetClientIdResp)
+	if err := s.Execute(&ProtoCommand{
+		Code:     Messages["GetClientIdReq"],
+		RespCode: Messages["GetClientIdResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	return out.(*rpb.RpbGetClientIdResp), nil
+	return out, nil
 }
 
-// SetClientId sets the id for this client.
+// SetClientId sets the id for this client.  The id is set again whenever the session redials.
 func (s *Session) SetClientId(id []byte) (bool, error) {
 	opt := &rpb.RpbSetClientIdReq{
 		ClientId: id,
 	}
-	in, err := proto.Marshal(opt)
-	if err != nil {
-		return false, err
-	}
-	out, err := s.execute(Messages["SetClientIdReq"], in)
-	if err != nil {
+	if err := s.Execute(&ProtoCommand{
+		Code:     Messages["SetClientIdReq"],
+		Req:      opt,
+		RespCode: Messages["SetClientIdResp"],
+	}); err != nil {
 		return false, err
 	}
-	return out.(bool), nil
+	s.clientId = id
+	s.node.setClientId(s, id)
+	return true, nil
 }
 
 // ServerInfo is a method which returns the information for the Riak cluster.
 func (s *Session) ServerInfo() (*rpb.RpbGetServerInfoResp, error) {
-	out, err := s.execute(Messages["GetServerInfoReq"], nil)
-	if err != nil {
+	out := new(rpb.RpbGetServerInfoResp)
+	if err := s.Execute(&ProtoCommand{
+		Code:     Messages["GetServerInfoReq"],
+		RespCode: Messages["GetServerInfoResp"],
+		Resp:     out,
+	}); err != nil {
 		return nil, err
 	}
-	return out.(*rpb.RpbGetServerInfoResp), nil
+	return out, nil
 }
@@ -1,7 +1,14 @@
 package riaken_core
 
 import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
 	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
 )
 
 func TestSessionMultiple(t *testing.T) {
@@ -38,3 +45,83 @@ func TestSessionMultiple(t *testing.T) {
 		t.Errorf("expected: %s, got: %s", s2name, string(out2.GetClientId()))
 	}
 }
+
+// fakeHandler answers a single request frame for fakeServer.
+type fakeHandler func(code byte, in []byte) (byte, []byte)
+
+// fakeServer starts a local server which speaks the Riak PB framing and answers with handler.
+//
+// It returns the address to dial and a function to stop the server.
+func fakeServer(t testing.TB, handler fakeHandler) (string, func()) {
+	return fakeServerVersion(t, "2.1.4", handler)
+}
+
+// fakeServerVersion is fakeServer reporting Riak version to server info requests.
+func fakeServerVersion(t testing.TB, version string, handler fakeHandler) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeServe(conn, version, handler)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+// fakeServe answers requests on conn with handler until it is closed.
+func fakeServe(conn net.Conn, version string, handler fakeHandler) {
+	info, _ := proto.Marshal(&rpb.RpbGetServerInfoResp{
+		Node:          []byte("riak@127.0.0.1"),
+		ServerVersion: []byte(version),
+	})
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	head := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(head))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		var code byte
+		var out []byte
+		if frame[0] == Messages["GetServerInfoReq"] {
+			code, out = Messages["GetServerInfoResp"], info
+		} else {
+			code, out = handler(frame[0], frame[1:])
+		}
+		resp := make([]byte, 5, 5+len(out))
+		binary.BigEndian.PutUint32(resp, uint32(len(out)+1))
+		resp[4] = code
+		if _, err := conn.Write(append(resp, out...)); err != nil {
+			return
+		}
+	}
+}
+
+// fakeEcho is a fakeHandler which acknowledges every request with an empty response.
+func fakeEcho(code byte, in []byte) (byte, []byte) {
+	return code + 1, nil
+}
+
+// fakeSession dials a standalone session against a fakeServer.
+func fakeSession(t testing.TB, handler fakeHandler) (*Session, func()) {
+	addr, stop := fakeServer(t, handler)
+	s := NewSession(make(chan *Session, 1), addr)
+	if err := s.Dial(); err != nil {
+		stop()
+		t.Fatal(err.Error())
+	}
+	return s, func() {
+		s.Close()
+		stop()
+	}
+}
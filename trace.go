@@ -0,0 +1,101 @@
+package riaken_core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Span is a single traced Riak operation.
+type Span interface {
+	SetAttributes(fields ...Field)
+	End(err error)
+}
+
+// Tracer starts a span for each Riak operation such as Fetch, Store, DtUpdate, MapReduce or a 2i page.
+//
+// The shape follows OpenTelemetry so an adapter only needs to wrap trace.Tracer.Start and
+// convert fields into attributes.  Spans carry the attributes riak.bucket_type, riak.bucket,
+// riak.key, riak.node, riak.opcode and riak.response_size, and Update spans riak.retries.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// span is the active traced operation on a session.
+type span struct {
+	session *Session
+	span    Span
+	parent  context.Context // session context to restore when the span ends
+}
+
+// WithContext sets the context used as the parent of trace spans for operations on this session.
+// The context is cleared when the session is released.  This call can be chained.
+func (s *Session) WithContext(ctx context.Context) *Session {
+	s.ctx = ctx
+	return s
+}
+
+// Context returns the context set by WithContext.  While an operation is traced it is the
+// context of its span, so spans started from it nest under the operation.
+func (s *Session) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// trace starts a span for operation op on key in bucket of type btype, if the client has a tracer.
+//
+// Operations made while a span is already active are covered by that span.
+func (s *Session) trace(op, btype, bucket, key string) *span {
+	c := s.client()
+	if c == nil || c.tracer == nil || s.span != nil {
+		return nil
+	}
+	ctx, sp := c.tracer.Start(s.Context(), op)
+	if key != "" && c.hashKeys {
+		sum := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(sum[:8])
+	}
+	fields := []Field{{"riak.node", s.addr}}
+	if bucket != "" {
+		fields = append(fields, Field{"riak.bucket_type", btype}, Field{"riak.bucket", bucket})
+	}
+	if key != "" {
+		fields = append(fields, Field{"riak.key", key})
+	}
+	sp.SetAttributes(fields...)
+	s.span = &span{
+		session: s,
+		span:    sp,
+		parent:  s.ctx,
+	}
+	s.ctx = ctx
+	return s.span
+}
+
+// request records the opcode and response size of a request made within the span.
+func (sp *span) request(code byte, size int) {
+	if sp == nil {
+		return
+	}
+	sp.span.SetAttributes(Field{"riak.opcode", Codes[code]}, Field{"riak.response_size", size})
+}
+
+// retries records the number of times the traced operation was retried so far.
+func (sp *span) retries(n int) {
+	if sp == nil {
+		return
+	}
+	sp.span.SetAttributes(Field{"riak.retries", n})
+}
+
+// end finishes the span with the error pointed to by err.
+func (sp *span) end(err *error) {
+	if sp == nil {
+		return
+	}
+	sp.span.End(*err)
+	sp.session.span = nil
+	sp.session.ctx = sp.parent
+}
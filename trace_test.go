@@ -0,0 +1,197 @@
+package riaken_core
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+type testSpan struct {
+	name    string
+	parent  context.Context
+	attrs   map[string]interface{}
+	ended   bool
+	err     error
+	session *Session        // session to take the context of while the span is active
+	during  context.Context // context of session when the attributes were last set
+}
+
+func (s *testSpan) SetAttributes(fields ...Field) {
+	for _, f := range fields {
+		s.attrs[f.Key] = f.Value
+	}
+	if s.session != nil {
+		s.during = s.session.Context()
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	mu      sync.Mutex
+	spans   []*testSpan
+	session *Session
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{name: name, parent: ctx, attrs: make(map[string]interface{}), session: t.session}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type traceKey struct{}
+
+type spanKey struct{}
+
+func TestSessionTrace(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	tracer := &testTracer{}
+	client := NewClient([]string{addr}, 1)
+	client.SetTracer(tracer, false)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "parent")
+	session := client.Session().WithContext(ctx)
+	bucket := session.GetBucket("b1").Type("t1")
+	if _, err := bucket.Object("k1").Fetch(); err != nil {
+		t.Fatal(err.Error())
+	}
+	session.Release()
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected: %d spans, got: %d", 1, len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if s.name != "Fetch" || !s.ended || s.err != nil {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	if s.parent.Value(traceKey{}) != "parent" {
+		t.Error("span did not use the session context")
+	}
+	expected := map[string]interface{}{
+		"riak.node":          addr,
+		"riak.bucket_type":   "t1",
+		"riak.bucket":        "b1",
+		"riak.key":           "k1",
+		"riak.opcode":        "GetReq",
+		"riak.response_size": 5,
+	}
+	for k, v := range expected {
+		if s.attrs[k] != v {
+			t.Errorf("%s expected: %v, got: %v", k, v, s.attrs[k])
+		}
+	}
+}
+
+func TestSessionTraceContext(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	tracer := &testTracer{}
+	client := NewClient([]string{addr}, 1)
+	client.SetTracer(tracer, false)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+	tracer.session = session
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "parent")
+	session.WithContext(ctx)
+	if _, err := session.GetBucket("b1").Object("k1").Fetch(); err != nil {
+		t.Fatal(err.Error())
+	}
+	s := tracer.spans[0]
+	if s.during == nil || s.during.Value(spanKey{}) != s || s.during.Value(traceKey{}) != "parent" {
+		t.Error("expected the span context during the operation")
+	}
+	if session.Context() != ctx {
+		t.Error("expected the session context back after the operation")
+	}
+}
+
+func TestSessionTraceRetries(t *testing.T) {
+	puts := 0
+	addr, stop := fakeServer(t, func(code byte, in []byte) (byte, []byte) {
+		switch code {
+		case Messages["GetReq"]:
+			out, _ := proto.Marshal(&rpb.RpbGetResp{
+				Content: []*rpb.RpbContent{{Value: []byte("1")}},
+				Vclock:  []byte("vclock"),
+			})
+			return Messages["GetResp"], out
+		case Messages["PutReq"]:
+			puts++
+			if puts < 3 {
+				out, _ := proto.Marshal(&rpb.RpbErrorResp{Errmsg: []byte("modified"), Errcode: proto.Uint32(1)})
+				return Messages["ErrorResp"], out
+			}
+		}
+		return fakeEcho(code, in)
+	})
+	defer stop()
+	tracer := &testTracer{}
+	client := NewClient([]string{addr}, 1)
+	client.SetTracer(tracer, false)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	object := session.GetBucket("b1").Object("k1")
+	if _, err := object.Update(func(old []byte) ([]byte, error) { return old, nil }); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := object.Fetch(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected: %d spans, got: %d", 2, len(tracer.spans))
+	}
+	if s := tracer.spans[0]; s.name != "Update" || s.attrs["riak.retries"] != 2 {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	if _, ok := tracer.spans[1].attrs["riak.retries"]; ok {
+		t.Error("expected no retry count on Fetch")
+	}
+}
+
+func TestSessionTraceHashKeys(t *testing.T) {
+	addr, stop := fakeServer(t, fakeEcho)
+	defer stop()
+	tracer := &testTracer{}
+	client := NewClient([]string{addr}, 1)
+	client.SetTracer(tracer, true)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+
+	session := client.Session()
+	defer session.Release()
+	if _, err := session.GetBucket("b1").Object("secret").Delete(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected: %d spans, got: %d", 1, len(tracer.spans))
+	}
+	key, _ := tracer.spans[0].attrs["riak.key"].(string)
+	if key == "" || key == "secret" {
+		t.Errorf("expected a hashed key, got: %q", key)
+	}
+}
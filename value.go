@@ -0,0 +1,117 @@
+package riaken_core
+
+import (
+	"encoding/base64"
+	"sort"
+	"time"
+
+	"github.com/riaken/riaken-core/rpb"
+)
+
+// VClock is an opaque Riak vector clock.
+type VClock []byte
+
+// String returns the vector clock encoded as base64, suitable for an HTTP ETag.
+func (v VClock) String() string {
+	return base64.StdEncoding.EncodeToString(v)
+}
+
+// Value is a single value of an object, or one of its siblings, with its metadata.
+type Value struct {
+	Data         []byte
+	ContentType  string
+	Charset      string
+	Encoding     string
+	VTag         string    // set by Riak
+	LastModified time.Time // set by Riak
+	Meta         map[string]string
+	Indexes      map[string][]string
+	Links        []Link
+	Deleted      bool // set by Riak
+}
+
+// newValue converts c into a Value.
+func newValue(c *rpb.RpbContent) Value {
+	return Value{
+		Data:         c.GetValue(),
+		ContentType:  string(c.GetContentType()),
+		Charset:      string(c.GetCharset()),
+		Encoding:     string(c.GetContentEncoding()),
+		VTag:         string(c.GetVtag()),
+		LastModified: lastModified(c),
+		Meta:         userMeta(c),
+		Indexes:      indexes(c),
+		Links:        links(c),
+		Deleted:      c.GetDeleted(),
+	}
+}
+
+// content converts v into the RpbContent sent with a Store.  Fields set by Riak are left out.
+func (v Value) content() *rpb.RpbContent {
+	c := &rpb.RpbContent{
+		Value: v.Data,
+	}
+	if v.ContentType != "" {
+		c.ContentType = []byte(v.ContentType)
+	}
+	if v.Charset != "" {
+		c.Charset = []byte(v.Charset)
+	}
+	if v.Encoding != "" {
+		c.ContentEncoding = []byte(v.Encoding)
+	}
+	for _, k := range sortedKeys(v.Meta) {
+		c.Usermeta = append(c.Usermeta, &rpb.RpbPair{Key: []byte(k), Value: []byte(v.Meta[k])})
+	}
+	idx := make([]string, 0, len(v.Indexes))
+	for k := range v.Indexes {
+		idx = append(idx, k)
+	}
+	sort.Strings(idx)
+	for _, k := range idx {
+		for _, val := range v.Indexes[k] {
+			c.Indexes = append(c.Indexes, &rpb.RpbPair{Key: []byte(k), Value: []byte(val)})
+		}
+	}
+	for _, l := range v.Links {
+		c.Links = append(c.Links, &rpb.RpbLink{Bucket: []byte(l.Bucket), Key: []byte(l.Key), Tag: []byte(l.Tag)})
+	}
+	return c
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FetchValue returns the values of this object, one per sibling, and its vector clock.
+//
+// No values are returned if the object does not exist.  Tombstones of a deleted object are
+// returned with Deleted set, along with the vector clock to store over them.
+func (o *Object) FetchValue() ([]Value, VClock, error) {
+	res, err := o.Fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+	var values []Value
+	for _, c := range res.GetContent() {
+		values = append(values, newValue(c))
+	}
+	return values, VClock(res.GetVclock()), nil
+}
+
+// StoreValue stores v with its metadata, using the vector clock held by this object.
+func (o *Object) StoreValue(v Value) error {
+	opts, err := o.putOpts()
+	if err != nil {
+		return err
+	}
+	opts.Content = v.content()
+	_, err = o.Store(v.Data)
+	return err
+}
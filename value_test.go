@@ -0,0 +1,98 @@
+package riaken_core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/riaken/riaken-core/rpb"
+)
+
+func TestObjectValue(t *testing.T) {
+	client := dial()
+	defer client.Close()
+	session := client.Session()
+	defer session.Release()
+
+	object := session.GetBucket("b1").Object("value1")
+	err := object.StoreValue(Value{
+		Data:        []byte(`{"a":1}`),
+		ContentType: "application/json",
+		Meta:        map[string]string{"owner": "me"},
+		Indexes:     map[string][]string{"color_bin": {"red"}},
+	})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	values, vclock, err := object.FetchValue()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(values) != 1 || len(vclock) == 0 {
+		t.Fatalf("expected: 1 value and a vclock, got: %d values", len(values))
+	}
+	v := values[0]
+	if string(v.Data) != `{"a":1}` || v.ContentType != "application/json" || v.Meta["owner"] != "me" || v.LastModified.IsZero() {
+		t.Errorf("unexpected value: %+v", v)
+	}
+	if _, err := object.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestObjectValueFake(t *testing.T) {
+	var put *rpb.RpbPutReq
+	session, stop := fakeSession(t, func(code byte, in []byte) (byte, []byte) {
+		if code == Messages["PutReq"] {
+			put = &rpb.RpbPutReq{}
+			proto.Unmarshal(in, put)
+			return code + 1, nil
+		}
+		out, _ := proto.Marshal(&rpb.RpbGetResp{
+			Content: []*rpb.RpbContent{
+				{Value: []byte("one"), ContentType: []byte("text/plain"), LastMod: proto.Uint32(1400000000)},
+				{Value: []byte("two"), Deleted: proto.Bool(true)},
+			},
+			Vclock: []byte("vclock"),
+		})
+		return Messages["GetResp"], out
+	})
+	defer stop()
+
+	object := session.GetBucket("b1").Object("o1")
+	values, vclock, err := object.FetchValue()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(values) != 2 || string(vclock) != "vclock" {
+		t.Fatalf("unexpected fetch: %v %s", values, vclock)
+	}
+	if string(values[0].Data) != "one" || values[0].ContentType != "text/plain" || !values[0].LastModified.Equal(time.Unix(1400000000, 0)) {
+		t.Errorf("unexpected value: %+v", values[0])
+	}
+	if !values[1].Deleted {
+		t.Errorf("expected a deleted sibling: %+v", values[1])
+	}
+	if vclock.String() != object.VClockString() || object.VClock().String() != vclock.String() {
+		t.Errorf("expected: %s, got: %s", object.VClockString(), vclock)
+	}
+
+	err = object.StoreValue(Value{
+		Data:     []byte("three"),
+		Charset:  "utf-8",
+		Meta:     map[string]string{"b": "2", "a": "1"},
+		Indexes:  map[string][]string{"n_int": {"1", "2"}},
+		Links:    []Link{{"b2", "k2", "tag"}},
+		Encoding: "gzip",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := put.GetContent()
+	if string(c.GetValue()) != "three" || string(c.GetCharset()) != "utf-8" || string(c.GetContentEncoding()) != "gzip" || string(put.GetVclock()) != "vclock" {
+		t.Errorf("unexpected content: %v", put)
+	}
+	if len(c.GetUsermeta()) != 2 || string(c.GetUsermeta()[0].GetKey()) != "a" || len(c.GetIndexes()) != 2 || len(c.GetLinks()) != 1 {
+		t.Errorf("unexpected content: %v", c)
+	}
+}
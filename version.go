@@ -0,0 +1,188 @@
+package riaken_core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerVersion is the parsed Riak version reported by a node.
+type ServerVersion struct {
+	Major int
+	Minor int
+	Patch int
+	Raw   string // version as reported, e.g. "2.1.4"
+}
+
+// ParseServerVersion parses a Riak version such as "1.4.12" or "2.0.0p1".  Anything after
+// the leading digits of each part is ignored.
+func ParseServerVersion(v string) (ServerVersion, error) {
+	out := ServerVersion{Raw: v}
+	parts := strings.SplitN(v, ".", 3)
+	nums := []*int{&out.Major, &out.Minor, &out.Patch}
+	for i, part := range parts {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			if i == 0 {
+				return ServerVersion{}, errors.New("invalid Riak version: " + v)
+			}
+			break
+		}
+		*nums[i] = n
+		if end < len(part) {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Known reports whether the version has been read from the node.
+func (v ServerVersion) Known() bool {
+	return v.Raw != ""
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as, or newer than o.
+func (v ServerVersion) Compare(o ServerVersion) int {
+	a := []int{v.Major, v.Minor, v.Patch}
+	b := []int{o.Major, o.Minor, o.Patch}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast reports whether v is major.minor.patch or newer.
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	return v.Compare(ServerVersion{Major: major, Minor: minor, Patch: patch}) >= 0
+}
+
+func (v ServerVersion) String() string {
+	if v.Raw != "" {
+		return v.Raw
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Feature is a Riak capability which depends on the server version.
+type Feature int
+
+const (
+	FEATURE_COUNTERS      Feature = 0 // 1.4 counters
+	FEATURE_2I_PAGINATION Feature = 1 // secondary index pagination and max results
+	FEATURE_BUCKET_TYPES  Feature = 2 // bucket types
+	FEATURE_CRDT_MAPS     Feature = 3 // data types, maps among them, through DtFetch and DtUpdate
+	FEATURE_YOKOZUNA      Feature = 4 // Yokozuna search indexes and schemas
+	FEATURE_SECURITY      Feature = 5 // authentication and TLS
+)
+
+func (f Feature) String() string {
+	switch f {
+	case FEATURE_COUNTERS:
+		return "counters"
+	case FEATURE_2I_PAGINATION:
+		return "2i pagination"
+	case FEATURE_BUCKET_TYPES:
+		return "bucket types"
+	case FEATURE_CRDT_MAPS:
+		return "CRDT maps"
+	case FEATURE_YOKOZUNA:
+		return "Yokozuna"
+	case FEATURE_SECURITY:
+		return "security"
+	}
+	return "unknown"
+}
+
+// Supports reports whether a node running v has feature f.
+func (v ServerVersion) Supports(f Feature) bool {
+	switch f {
+	case FEATURE_COUNTERS, FEATURE_2I_PAGINATION:
+		return v.AtLeast(1, 4, 0)
+	case FEATURE_BUCKET_TYPES, FEATURE_CRDT_MAPS, FEATURE_YOKOZUNA, FEATURE_SECURITY:
+		return v.AtLeast(2, 0, 0)
+	}
+	return false
+}
+
+// UnsupportedError is returned for a call the connected node cannot handle, before it is sent.
+type UnsupportedError struct {
+	Feature Feature
+	Node    string
+	Version ServerVersion
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s not supported by node %s running Riak %s", e.Feature, e.Node, e.Version)
+}
+
+// featureCodes maps request codes to the feature they need.
+var featureCodes = map[byte]Feature{
+	Messages["GetBucketTypeReq"]:       FEATURE_BUCKET_TYPES,
+	Messages["SetBucketTypeReq"]:       FEATURE_BUCKET_TYPES,
+	Messages["ResetBucketTypeReq"]:     FEATURE_BUCKET_TYPES,
+	Messages["CounterUpdateReq"]:       FEATURE_COUNTERS,
+	Messages["CounterGetReq"]:          FEATURE_COUNTERS,
+	Messages["YokozunaIndexGetReq"]:    FEATURE_YOKOZUNA,
+	Messages["YokozunaIndexPutReq"]:    FEATURE_YOKOZUNA,
+	Messages["YokozunaIndexDeleteReq"]: FEATURE_YOKOZUNA,
+	Messages["YokozunaSchemaGetReq"]:   FEATURE_YOKOZUNA,
+	Messages["YokozunaSchemaPutReq"]:   FEATURE_YOKOZUNA,
+	Messages["DtFetchReq"]:             FEATURE_CRDT_MAPS,
+	Messages["DtUpdateReq"]:            FEATURE_CRDT_MAPS,
+	Messages["AuthReq"]:                FEATURE_SECURITY,
+	Messages["StartTls"]:               FEATURE_SECURITY,
+}
+
+// require returns an UnsupportedError if the node of this session is known not to have feature f.
+func (s *Session) require(f Feature) error {
+	v := s.node.Version()
+	if !v.Known() || v.Supports(f) {
+		return nil
+	}
+	return &UnsupportedError{
+		Feature: f,
+		Node:    s.addr,
+		Version: v,
+	}
+}
+
+// requireCode is require for the feature needed by request code, if any.
+func (s *Session) requireCode(code byte) error {
+	if f, ok := featureCodes[code]; ok {
+		return s.require(f)
+	}
+	return nil
+}
+
+// requireType is require for bucket types if btype is set to anything but the default type.
+func (s *Session) requireType(btype []byte) error {
+	if len(btype) == 0 || string(btype) == "default" {
+		return nil
+	}
+	return s.require(FEATURE_BUCKET_TYPES)
+}
+
+// applyServerInfo records the version of the node after a dial.
+func (s *Session) applyServerInfo() {
+	info, err := s.ServerInfo()
+	if err != nil {
+		s.log().Log(LOG_INFO, "server info failed", Field{"node", s.addr}, Field{"error", err})
+		return
+	}
+	v, err := ParseServerVersion(string(info.GetServerVersion()))
+	if err != nil {
+		s.log().Log(LOG_INFO, "server info failed", Field{"node", s.addr}, Field{"error", err})
+		return
+	}
+	s.node.setVersion(v)
+}
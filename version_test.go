@@ -0,0 +1,78 @@
+package riaken_core
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		in                  string
+		major, minor, patch int
+	}{
+		{"2.1.4", 2, 1, 4},
+		{"1.4.12", 1, 4, 12},
+		{"2.0.0p1", 2, 0, 0},
+		{"2.9", 2, 9, 0},
+		{"3.0.0-rc1", 3, 0, 0},
+	}
+	for _, test := range tests {
+		v, err := ParseServerVersion(test.in)
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err.Error())
+			continue
+		}
+		if v.Major != test.major || v.Minor != test.minor || v.Patch != test.patch || v.String() != test.in {
+			t.Errorf("%s: unexpected version: %+v", test.in, v)
+		}
+	}
+	if _, err := ParseServerVersion("riak"); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+
+	v14, _ := ParseServerVersion("1.4.12")
+	v20, _ := ParseServerVersion("2.0.0")
+	if v14.Compare(v20) != -1 || v20.Compare(v14) != 1 || v20.Compare(v20) != 0 {
+		t.Error("unexpected version ordering")
+	}
+	if !v14.AtLeast(1, 4, 0) || v14.AtLeast(1, 4, 13) || !v20.AtLeast(1, 4, 12) {
+		t.Error("unexpected AtLeast result")
+	}
+	if !v14.Supports(FEATURE_2I_PAGINATION) || v14.Supports(FEATURE_BUCKET_TYPES) || !v20.Supports(FEATURE_CRDT_MAPS) {
+		t.Error("unexpected feature support")
+	}
+}
+
+func TestSessionRequire(t *testing.T) {
+	var sent int32
+	addr, stop := fakeServerVersion(t, "1.4.12", func(code byte, in []byte) (byte, []byte) {
+		atomic.AddInt32(&sent, 1)
+		return fakeEcho(code, in)
+	})
+	defer stop()
+	client := NewClient([]string{addr}, 1)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	if v := client.NodeStatus()[0].Version; v.String() != "1.4.12" {
+		t.Errorf("expected: 1.4.12, got: %s", v)
+	}
+	session := client.Session()
+	defer session.Release()
+
+	_, err := session.GetBucket("b1").Crdt("c1").Fetch()
+	if e, ok := err.(*UnsupportedError); !ok || e.Feature != FEATURE_CRDT_MAPS {
+		t.Errorf("expected an unsupported CRDT error, got: %v", err)
+	}
+	_, err = session.GetBucket("b1").Type("t1").Object("o1").Fetch()
+	if e, ok := err.(*UnsupportedError); !ok || e.Feature != FEATURE_BUCKET_TYPES {
+		t.Errorf("expected an unsupported bucket type error, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&sent); n != 0 {
+		t.Errorf("expected no requests to be sent, got: %d", n)
+	}
+	if _, err := session.GetBucket("b1").Object("o1").Fetch(); err != nil {
+		t.Error(err.Error())
+	}
+}